@@ -2,208 +2,182 @@ package controllers
 
 import (
 	"context"
-	"fmt"
+	"errors"
+	"go-ecommerce/database"
+	"go-ecommerce/dto"
 	"go-ecommerce/models"
-	"log"
+	"go-ecommerce/postal"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func AddAddress() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		id := c.Query("id")
-
-		if id == "" {
-			log.Println("id is empty")
+// addressStatus maps address book errors to response codes
+func addressStatus(err error) int {
+	switch err {
+	case database.ErrAddressNotFound, database.ErrUserNotFound:
+		return http.StatusNotFound
+	case database.ErrAddressIdIsNotValid, database.ErrUserIdIsNotValid:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
 
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid id"})
-			c.Abort()
-			return
-		}
-		addressId, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			c.IndentedJSON(500, "Internal Server Error")
-			return
-		}
+// addressIdParam parses the :addressId path parameter, aborting with 400 when invalid
+func addressIdParam(c *gin.Context) (primitive.ObjectID, bool) {
+	addressId, err := primitive.ObjectIDFromHex(c.Param("addressId"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": database.ErrAddressIdIsNotValid.Error()})
+		return primitive.NilObjectID, false
+	}
+	return addressId, true
+}
 
-		//find out how many addresses the user has
-		var addresses models.Address
+// bindAddress reads an address request body and normalizes it for its country
+func bindAddress(c *gin.Context) (models.Address, bool) {
+	var req dto.AddressRequest
+	if err := c.BindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return models.Address{}, false
+	}
+	if err := Validate.Struct(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return models.Address{}, false
+	}
+	return normalizeAddress(c, dto.NewAddress(req))
+}
 
-		addresses.AddressId = primitive.NewObjectID()
+// normalizeAddress checks that address can be delivered to. When it cannot, it responds
+// with 422 and the problem with each field.
+func normalizeAddress(c *gin.Context, address models.Address) (models.Address, bool) {
+	normalized, err := postal.Normalize(address)
+	if err != nil {
+		var invalid *postal.ValidationError
+		if errors.As(err, &invalid) {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": invalid.Error(), "fields": invalid.Fields})
+		} else {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return models.Address{}, false
+	}
+	return normalized, true
+}
 
-		if err = c.BindJSON(&addressId); err != nil {
-			c.IndentedJSON(http.StatusNotAcceptable, err.Error())
+func ListAddresses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		// create aggregation query
-		// match finds the particular user with user id and its data
-		matchFilter := bson.D{{Key: "$match", Value: bson.D{primitive.E{Key: "_id", Value: addressId}}}}
-		// unwind stage will unwind the userCart data from a closed array to something that can be processed in go
-		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$address"}}}}
-
-		// grouping stage groups all the values with the help of id and find the total price
-		grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "$_id", Value: "$addressId"}, {Key: "count", Value: bson.D{primitive.E{Key: "$sum", Value: 1}}}}}}
-		pointCursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{matchFilter, unwind, grouping})
-
+		addresses, err := database.ListAddresses(ctx, UserCollection, userId)
 		if err != nil {
-			c.IndentedJSON(500, "Internal Server Error")
+			c.JSON(addressStatus(err), gin.H{"error": err.Error()})
+			return
 		}
+		c.JSON(http.StatusOK, dto.NewAddressResponses(addresses))
+	}
+}
 
-		var addressInfo []bson.M
-		if err = pointCursor.All(ctx, &addressInfo); err != nil {
-			panic(err)
+func GetAddress() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
 		}
-
-		var size int32
-		for _, addressNo := range addressInfo {
-			count := addressNo["count"]
-			size = count.(int32)
+		addressId, ok := addressIdParam(c)
+		if !ok {
+			return
 		}
 
-		if size < 2 {
-			filter := bson.D{primitive.E{Key: "_id", Value: addressId}}
-			update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "address", Value: addresses}}}}
-			if _, err := UserCollection.UpdateOne(ctx, filter, update); err != nil {
-				fmt.Println(err)
-			}
-
-		} else {
-			c.IndentedJSON(400, "Not allowed as have 2 addresses already")
-		}
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		ctx.Done()
 
+		address, err := database.FindAddress(ctx, UserCollection, userId, addressId)
+		if err != nil {
+			c.JSON(addressStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, dto.NewAddressResponse(*address))
 	}
 }
 
-func EditHomeAddress() gin.HandlerFunc {
+// AddAddress adds an entry to the address book. Setting a default flag moves it from
+// the address that had it before.
+func AddAddress() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Query("id")
-
-		if id == "" {
-			log.Println("id is empty")
-
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid search index"})
-			c.Abort()
+		userId, ok := currentUserId(c)
+		if !ok {
 			return
 		}
-
-		userId, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			c.IndentedJSON(500, "Internal Server Error")
+		address, ok := bindAddress(c)
+		if !ok {
 			return
 		}
 
-		var editAddress models.Address
-		if err := c.BindJSON(&editAddress); err != nil {
-			c.IndentedJSON(http.StatusBadRequest, err.Error())
-		}
-
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		filter := bson.D{primitive.E{Key: "_id", Value: userId}}
-		// update address values - home address is at 0th index
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address.0.house", Value: editAddress.House}, {Key: "address.0.street", Value: editAddress.Street}, {Key: "address.0.city", Value: editAddress.City}, {Key: "address.0.pinCode", Value: editAddress.PinCode}}}}
-
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
+		created, err := database.AddAddress(ctx, UserCollection, userId, address)
 		if err != nil {
-			c.IndentedJSON(500, "Something went wrong")
+			c.JSON(addressStatus(err), gin.H{"error": err.Error()})
 			return
 		}
-		defer cancel()
-		ctx.Done()
-		c.IndentedJSON(200, "Successfully update dthe home address")
+		c.JSON(http.StatusCreated, dto.NewAddressResponse(*created))
 	}
 }
 
-func EditWorkAddress() gin.HandlerFunc {
+// UpdateAddress replaces an entry of the address book
+func UpdateAddress() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Query("id")
-
-		if id == "" {
-			log.Println("id is empty")
-
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid search index"})
-			c.Abort()
+		userId, ok := currentUserId(c)
+		if !ok {
 			return
 		}
-
-		userId, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			c.IndentedJSON(500, "Internal Server Error")
+		addressId, ok := addressIdParam(c)
+		if !ok {
 			return
 		}
-
-		var editAddress models.Address
-		if err := c.BindJSON(&editAddress); err != nil {
-			c.IndentedJSON(http.StatusBadRequest, err.Error())
+		address, ok := bindAddress(c)
+		if !ok {
+			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		filter := bson.D{primitive.E{Key: "_id", Value: userId}}
-		// update address values - workaddress is at 0th index
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address.1.house", Value: editAddress.House}, {Key: "address.1.street", Value: editAddress.Street}, {Key: "address.1.city", Value: editAddress.City}, {Key: "address.1.pinCode", Value: editAddress.PinCode}}}}
-
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.IndentedJSON(500, "Something went wrong")
+		address.AddressId = addressId
+		if err := database.UpdateAddress(ctx, UserCollection, userId, address); err != nil {
+			c.JSON(addressStatus(err), gin.H{"error": err.Error()})
 			return
 		}
-		defer cancel()
-		ctx.Done()
-		c.IndentedJSON(200, "Successfully updated the work address")
+		c.JSON(http.StatusOK, dto.NewAddressResponse(address))
 	}
 }
 
 func DeleteAddress() gin.HandlerFunc {
-
 	return func(c *gin.Context) {
-		id := c.Query("id")
-
-		if id == "" {
-			log.Println("id is empty")
-
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid search index"})
-			c.Abort()
+		userId, ok := currentUserId(c)
+		if !ok {
 			return
 		}
-
-		addresses := make([]models.Address, 0)
-		userId, err := primitive.ObjectIDFromHex(id)
-		if err != nil {
-			c.IndentedJSON(500, "Internal Server Error")
+		addressId, ok := addressIdParam(c)
+		if !ok {
 			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		filter := bson.D{primitive.E{Key: "_id", Value: userId}}
-		update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "address", Value: addresses}}}} // set address to nil struct
-
-		_, err = UserCollection.UpdateOne(ctx, filter, update)
-		if err != nil {
-			c.IndentedJSON(404, "Wrong command")
+		if err := database.DeleteAddress(ctx, UserCollection, userId, addressId); err != nil {
+			c.JSON(addressStatus(err), gin.H{"error": err.Error()})
 			return
 		}
-		defer cancel()
-		ctx.Done()
-		c.IndentedJSON(200, "Successfully deleted")
-
+		c.JSON(http.StatusOK, "Successfully deleted the address")
 	}
 }
@@ -0,0 +1,54 @@
+package controllers
+
+import (
+	"context"
+	"go-ecommerce/database"
+	"go-ecommerce/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func SetUserRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Role string `json:"role"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !models.IsValidRole(body.Role) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": database.ErrInvalidRole.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		userId := c.Param("userId")
+		entry := models.AuditEntry{
+			ActorId:      c.GetString("uid"),
+			TargetUserId: userId,
+			Action:       "set role " + body.Role,
+			IP:           c.ClientIP(),
+		}
+		if err := database.RecordAudit(ctx, AuditCollection, entry); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := database.SetUserRole(ctx, UserCollection, userId, body.Role); err != nil {
+			status := http.StatusInternalServerError
+			if err == database.ErrUserNotFound {
+				status = http.StatusNotFound
+			} else if err == database.ErrUserIdIsNotValid {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Successfully updated the user role")
+	}
+}
@@ -3,7 +3,11 @@ package controllers
 import (
 	"context"
 	"fmt"
+	"go-ecommerce/database"
+	"go-ecommerce/dto"
+	"go-ecommerce/middleware"
 	"go-ecommerce/models"
+	"go-ecommerce/passwords"
 	generate "go-ecommerce/token"
 
 	"log"
@@ -13,39 +17,55 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"golang.org/x/crypto/bcrypt"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-func HashPassword(password string) string {
-	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
-	if err != nil {
-		log.Panic(err)
-	}
-	return string(bytes)
+func HashPassword(password string) (string, error) {
+	return passwords.Hash(password)
 }
 
 func VerifyPassword(userPassword, givenPassword string) (bool, string) {
-	err := bcrypt.CompareHashAndPassword([]byte(givenPassword), []byte(userPassword))
-	valid := true
+	valid, err := passwords.Verify(userPassword, givenPassword)
 	msg := ""
 
-	if err != nil {
+	if err != nil || !valid {
 		msg = "Login or Password is incorrect"
 		valid = false
 	}
 	return valid, msg
 }
 
+// rehashIfNeeded upgrades a stored hash from an older algorithm or weaker parameters
+// while the plaintext password is at hand
+func rehashIfNeeded(ctx context.Context, foundUser models.User, password string) {
+	if !passwords.NeedsRehash(*foundUser.Password) {
+		return
+	}
+	upgraded, err := HashPassword(password)
+	if err != nil {
+		log.Println("Error rehashing password: ", err)
+		return
+	}
+	if err := database.UpdatePassword(ctx, UserCollection, foundUser.UserId, upgraded); err != nil {
+		log.Println("Error storing rehashed password: ", err)
+	}
+}
+
 func Signup() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var user models.User
-		if err := c.BindJSON(&user); err != nil {
+		var req dto.SignupRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		user := dto.NewUser(req)
 
 		//check if email address exists
 		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": user.Email})
@@ -57,6 +77,7 @@ func Signup() gin.HandlerFunc {
 
 		if count > 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "User already exists"})
+			return
 		}
 
 		//check if phone exists
@@ -67,28 +88,49 @@ func Signup() gin.HandlerFunc {
 		}
 		if count > 0 {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "This phone number is already in use"})
+			return
 		}
 
-		password := HashPassword(*user.Password)
+		if err := passwords.DefaultPolicy.Check(req.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		password, err := HashPassword(req.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "The user did not get created"})
+			return
+		}
 		user.Password = &password
 
 		user.CreatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.UpdatedAt, _ = time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
 		user.ID = primitive.NewObjectID()
 		user.UserId = user.ID.Hex()
+		// roles are only ever granted by an admin, never taken from the signup body
+		user.Role = models.RoleCustomer
+		// the account stays unverified until the emailed link is opened
+		user.EmailVerified = false
+		user.EmailVerifiedAt = nil
 
-		token, refreshToken, _ := generate.TokenGenerator(*user.Email, *user.FirstName, *user.LastName, user.UserId)
-		user.Token = &token
-		user.RefreshToken = &refreshToken
 		user.UserCart = make([]models.UserProduct, 0)
 		user.AddressDetails = make([]models.Address, 0)
 		user.OrderStatus = make([]models.Order, 0)
 
-		if _, insertErr := UserCollection.InsertOne(ctx, user); insertErr != nil {
+		insertErr := database.WithEvents(ctx, OutboxCollection, func(ctx context.Context) error {
+			_, err := UserCollection.InsertOne(ctx, user)
+			return err
+		}, models.NewEvent(models.EventUserSignedUp, user.UserId, user.UserId))
+		if insertErr != nil {
 			fmt.Println("Error in creating user: ", insertErr)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "The user did not get created"})
+			return
+		}
 
+		if err := sendVerificationEmail(ctx, user.UserId, *user.Email); err != nil {
+			// the user can ask for a new link through the resend endpoint
+			log.Println("Error sending verification email: ", err)
 		}
+		c.JSON(http.StatusCreated, "Successfully signed up, check your email to verify your address")
 	}
 }
 
@@ -97,33 +139,99 @@ func Login() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		var user, foundUser models.User
-		// put the user details captured in the request context [c] in user model
-		if err := c.BindJSON(&user); err != nil {
+		var req dto.LoginRequest
+		var foundUser models.User
+		if err := c.BindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "email and password are required"})
+			return
+		}
+
+		if loginLocked(ctx, c, req.Email) {
+			return
+		}
 
-		if err := UserCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&foundUser); err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		if err := UserCollection.FindOne(ctx, bson.M{"email": req.Email}).Decode(&foundUser); err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Println(err)
+			}
+			burnPasswordCheck(req.Password)
+			loginFailed(ctx, c, req.Email, "")
+			return
+		}
+
+		// accounts created through an identity provider have no password to log in with
+		if foundUser.Password == nil {
+			burnPasswordCheck(req.Password)
+			loginFailed(ctx, c, req.Email, foundUser.UserId)
 			return
 		}
 
 		//verify password
-		passwordIsValid, msg := VerifyPassword(*user.Password, *foundUser.Password)
-		defer cancel()
+		passwordIsValid, msg := VerifyPassword(req.Password, *foundUser.Password)
 
 		if !passwordIsValid {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
 			fmt.Println("Error verifying password: ", msg)
+			loginFailed(ctx, c, req.Email, foundUser.UserId)
+			return
+		}
+		rehashIfNeeded(ctx, foundUser, req.Password)
+
+		firstFactorPassed(ctx, c, foundUser, c.Query("session") == "cookie")
+	}
+}
+
+// firstFactorPassed continues a login once the password or identity provider has been
+// checked. With two-factor authentication enabled this only earns a challenge, and the
+// failure counter is only reset once the second factor is passed too.
+func firstFactorPassed(ctx context.Context, c *gin.Context, foundUser models.User, cookieSession bool) {
+	if foundUser.TOTPEnabled {
+		mfaToken, err := generate.MFAChallengeGenerator(foundUser.UserId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
+		}
+		c.JSON(http.StatusOK, gin.H{"mfaRequired": true, "mfaToken": mfaToken})
+		return
+	}
+
+	loginSucceeded(ctx, *foundUser.Email)
+	completeLogin(c, foundUser, cookieSession)
+}
 
+// completeLogin starts a session for a fully authenticated user and writes the login
+// response. Browser clients may ask for the tokens in HttpOnly cookies instead.
+func completeLogin(c *gin.Context, foundUser models.User, cookieSession bool) {
+	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	token, refreshToken, err := startSession(ctx, c, foundUser)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// cookie sessions keep the tokens out of reach of scripts, so they are not echoed
+	if cookieSession {
+		csrfToken, err := middleware.SetSessionCookies(c, token, refreshToken)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
 		}
-		token, refreshToken, _ := generate.TokenGenerator(*foundUser.Email, *foundUser.FirstName, *foundUser.LastName, foundUser.UserId)
-		defer cancel() //WHY DEFER CANCEL ALL THE TIME??
+		c.Header(middleware.CSRFHeader, csrfToken)
+		c.JSON(http.StatusOK, dto.LoginResponse{User: dto.NewUserResponse(foundUser)})
+		return
+	}
+	c.JSON(http.StatusOK, dto.LoginResponse{User: dto.NewUserResponse(foundUser), Token: token, RefreshToken: refreshToken})
+}
 
-		// update user tokens
-		generate.UpdateAllTokens(token, refreshToken, foundUser.UserId)
-		c.JSON(http.StatusFound, foundUser)
+// JWKS publishes the public keys our tokens can be verified with
+func JWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, generate.PublicJWKS())
 	}
 }
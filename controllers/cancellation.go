@@ -0,0 +1,121 @@
+package controllers
+
+import (
+	"context"
+	"go-ecommerce/database"
+	"go-ecommerce/dto"
+	"go-ecommerce/models"
+	"go-ecommerce/payment"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultCancellationWindow = 24 * time.Hour
+
+// CancellationWindow reads ORDER_CANCELLATION_WINDOW, e.g. "2h", how long after
+// checkout a customer may still cancel an order that has not started shipping
+func CancellationWindow() time.Duration {
+	value := strings.TrimSpace(os.Getenv("ORDER_CANCELLATION_WINDOW"))
+	if value == "" {
+		return defaultCancellationWindow
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		log.Printf("invalid ORDER_CANCELLATION_WINDOW %q, using the default", value)
+		return defaultCancellationWindow
+	}
+	return window
+}
+
+// CancelOrder cancels an order that has not started shipping, within the cancellation
+// window. Its items go back into stock with the cancellation; the customer's email and
+// voiding or refunding a digital payment follow from the OrderCancelled event, whose
+// subscribers retry until they succeed.
+func (app *Application) CancelOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := targetUserId(c)
+		if !ok {
+			return
+		}
+
+		var req dto.CancelOrderRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		cancellation := models.Cancellation{
+			Reason:      req.Reason,
+			CancelledBy: c.GetString("uid"),
+			CancelledAt: time.Now(),
+		}
+		placedAfter := cancellation.CancelledAt.Add(-CancellationWindow())
+		order, err := database.CancelOrder(ctx, app.orderCollection, app.prodCollection, OutboxCollection, c.Param("orderId"), userId, placedAfter, cancellation)
+		if err != nil {
+			status := orderStatus(err)
+			if err == database.ErrOrderNotCancellable || err == database.ErrCancellationWindowClosed {
+				status = http.StatusConflict
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewOrderResponse(*order))
+	}
+}
+
+// reverseCharge gives back the whole payment of a cancelled order: the charge is voided
+// if nothing was refunded yet and the provider still allows it, or else whatever was not
+// refunded yet is refunded. It runs for the OrderCancelled event and may run again for
+// the same order, so it only reverses what is still captured.
+func (app *Application) reverseCharge(ctx context.Context, orderId string) error {
+	order, err := database.FindOrder(ctx, app.orderCollection, orderId, "")
+	if err != nil {
+		return err
+	}
+	if order.Charge == nil || order.Charge.Status != models.ChargeCaptured || order.Cancellation == nil {
+		return nil
+	}
+	cancellation := *order.Cancellation
+	if PaymentProvider == nil {
+		return payment.ErrNoProvider
+	}
+
+	if order.RefundedAmount == 0 {
+		err := PaymentProvider.Void(ctx, order.Charge.TransactionId)
+		// already voided: an earlier attempt stopped before storing it
+		if err == nil || err == payment.ErrAlreadyVoided {
+			charge := *order.Charge
+			charge.Status = models.ChargeVoided
+			order.Charge = &charge
+			if err == nil {
+				recordPayment(ctx, *order, models.LedgerVoid, cancellation.CancelledBy)
+			}
+			return database.SetOrderCharge(ctx, app.orderCollection, order.OrderId, charge)
+		}
+	}
+
+	r := refund{
+		Amount:  order.CapturedAmount() - order.RefundedAmount,
+		Source:  models.RefundForCancellation,
+		Reason:  cancellation.Reason,
+		ActorId: cancellation.CancelledBy,
+	}
+	if r.Amount <= 0 {
+		return nil
+	}
+	_, err = app.issueRefund(ctx, *order, r)
+	return err
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"go-ecommerce/database"
+	"go-ecommerce/dto"
 	"go-ecommerce/models"
 	"log"
 	"net/http"
@@ -16,12 +17,28 @@ import (
 )
 
 type Application struct {
-	prodCollection *mongo.Collection
-	userCollection *mongo.Collection
+	prodCollection  *mongo.Collection
+	userCollection  *mongo.Collection
+	orderCollection *mongo.Collection
 }
 
-func NewApplication(prodCollection, userCollection *mongo.Collection) *Application {
-	return &Application{prodCollection: prodCollection, userCollection: userCollection}
+func NewApplication(prodCollection, userCollection, orderCollection *mongo.Collection) *Application {
+	return &Application{prodCollection: prodCollection, userCollection: userCollection, orderCollection: orderCollection}
+}
+
+// requireVerifiedEmail blocks checkout for users who have not verified their email
+// address yet. Browsing and the cart stay available to them.
+func (app *Application) requireVerifiedEmail(ctx context.Context, c *gin.Context, userId string) bool {
+	verified, err := database.IsEmailVerified(ctx, app.userCollection, userId)
+	if err != nil {
+		c.IndentedJSON(http.StatusInternalServerError, err.Error())
+		return false
+	}
+	if !verified {
+		c.IndentedJSON(http.StatusForbidden, gin.H{"error": "Please verify your email address before checking out"})
+		return false
+	}
+	return true
 }
 
 func (app *Application) AddToCart() gin.HandlerFunc {
@@ -34,27 +51,26 @@ func (app *Application) AddToCart() gin.HandlerFunc {
 			return
 		}
 
-		userQueryId := c.Query("userId")
-		if userQueryId == "" {
-			log.Println("User ID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("user ID is empty"))
+		userId, ok := targetUserId(c)
+		if !ok {
 			return
 		}
 
-		// productId, err := primitive.ObjectIDFromHex(productQueryId)
+		productId, err := primitive.ObjectIDFromHex(productQueryId)
 
-		// if err != nil {
-		// 	log.Println(err)
-		// 	c.AbortWithStatus(http.StatusInternalServerError)
-		// 	return
-		// }
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		err := database.AddToCart(ctx, app.prodCollection, app.userCollection, productQueryId)
+		err = database.AddToCart(ctx, app.prodCollection, app.userCollection, OutboxCollection, productId, userId)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			c.IndentedJSON(http.StatusInternalServerError, err.Error())
+			return
 		}
 		c.IndentedJSON(http.StatusOK, "Successfully added to cart")
 	}
@@ -70,10 +86,8 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 			return
 		}
 
-		userQueryId := c.Query("userId")
-		if userQueryId == "" {
-			log.Println("User ID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("user ID is empty"))
+		userId, ok := targetUserId(c)
+		if !ok {
 			return
 		}
 
@@ -88,9 +102,10 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
 		defer cancel()
 
-		err = database.RemoveItemFromCart(ctx, app.prodCollection, app.userCollection, productId, userQueryId)
+		err = database.RemoveItemFromCart(ctx, app.prodCollection, app.userCollection, OutboxCollection, productId, userId)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			c.IndentedJSON(http.StatusInternalServerError, err.Error())
+			return
 		}
 		c.IndentedJSON(http.StatusOK, "Successfully removed product from cart")
 	}
@@ -98,25 +113,24 @@ func (app *Application) RemoveItem() gin.HandlerFunc {
 
 func (app *Application) GetItemFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Query("id")
-
-		if id == "" {
-			log.Println("id is empty")
-
-			c.Header("Content-Type", "application/json")
-			c.JSON(http.StatusNotFound, gin.H{"error": "Invalid id"})
-			c.Abort()
+		userQueryId, ok := targetUserId(c)
+		if !ok {
 			return
 		}
 
-		userId, _ := primitive.ObjectIDFromHex(id)
+		userId, err := primitive.ObjectIDFromHex(userQueryId)
+		if err != nil {
+			log.Println(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
 		var filledCart models.User
 
-		if err := UserCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: userId}}); err != nil {
+		if err := app.userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: userId}}).Decode(&filledCart); err != nil {
 			log.Println(err)
 			c.IndentedJSON(500, "Not found")
 			return
@@ -129,11 +143,13 @@ func (app *Application) GetItemFromCart() gin.HandlerFunc {
 		unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$userCart"}}}}
 
 		// grouping stage groups all the values with the help of id and find the total price
-		grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "$_id", Value: "$_id"}, {Key: "$sum", Value: "$userCart.price"}}}}
-		pointCursor, err := UserCollection.Aggregate(ctx, mongo.Pipeline{filterMatch, unwind, grouping})
+		grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$userCart.price"}}}}}}
+		pointCursor, err := app.userCollection.Aggregate(ctx, mongo.Pipeline{filterMatch, unwind, grouping})
 
 		if err != nil {
 			log.Println(err)
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
 		}
 
 		var listing []bson.M
@@ -141,37 +157,35 @@ func (app *Application) GetItemFromCart() gin.HandlerFunc {
 		if err = pointCursor.All(ctx, &listing); err != nil {
 			log.Println(err)
 			c.AbortWithStatus(http.StatusInternalServerError)
+			return
 		}
 
+		var total interface{} = 0
 		for _, json := range listing {
-			c.IndentedJSON(200, json["total"])
-			c.IndentedJSON(200, filledCart.UserCart)
+			total = json["total"]
 		}
-		ctx.Done()
+		c.IndentedJSON(200, gin.H{"total": total, "userCart": filledCart.UserCart})
 	}
 }
 
+// BuyFromCart checks the whole cart out to ?addressId, or else the default shipping
+// address, paying cash on delivery. POST /orders offers the full set of options.
 func (app *Application) BuyFromCart() gin.HandlerFunc {
 	return func(c *gin.Context) {
-
-		userQueryId := c.Query("userId")
-		if userQueryId == "" {
-			log.Println("User ID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("user ID is empty"))
+		userId, ok := targetUserId(c)
+		if !ok {
 			return
 		}
 
 		var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
 		defer cancel()
 
-		err := database.BuyItemFromCart(ctx, app.userCollection, userQueryId)
-		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
-		}
-		c.IndentedJSON(http.StatusOK, "Successfully placed the order")
+		app.checkoutCart(ctx, c, userId, dto.CheckoutRequest{PaymentMethod: dto.PaymentCOD})
 	}
 }
 
+// InstantBuy orders a single product without going through the cart, shipping it to
+// ?addressId or else the default shipping address and paying cash on delivery
 func (app *Application) InstantBuy() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		// you need user who is adding and product to be added.
@@ -182,10 +196,8 @@ func (app *Application) InstantBuy() gin.HandlerFunc {
 			return
 		}
 
-		userQueryId := c.Query("userId")
-		if userQueryId == "" {
-			log.Println("User ID is empty")
-			_ = c.AbortWithError(http.StatusBadRequest, errors.New("user ID is empty"))
+		userId, ok := targetUserId(c)
+		if !ok {
 			return
 		}
 
@@ -197,13 +209,24 @@ func (app *Application) InstantBuy() gin.HandlerFunc {
 			return
 		}
 
-		var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
 
-		err = database.InstantBuy(ctx, app.prodCollection, app.userCollection, productId, userQueryId)
+		if !app.requireVerifiedEmail(ctx, c, userId) {
+			return
+		}
+
+		product, err := database.FindProduct(ctx, app.prodCollection, productId)
 		if err != nil {
-			c.IndentedJSON(http.StatusInternalServerError, err)
+			c.IndentedJSON(http.StatusNotFound, err.Error())
+			return
+		}
+		order, ok := app.planOrder(ctx, c, userId, []models.UserProduct{*product}, dto.CheckoutRequest{PaymentMethod: dto.PaymentCOD})
+		if !ok {
+			return
 		}
-		c.IndentedJSON(http.StatusOK, "Successfully placed the order")
+		app.placeOrder(ctx, c, order, dto.CheckoutRequest{PaymentMethod: dto.PaymentCOD}, func(ctx context.Context, order models.Order) error {
+			return database.InstantBuy(ctx, app.orderCollection, order)
+		})
 	}
 }
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"html/template"
+	"log"
+
+	"github.com/gin-gonic/gin"
+)
+
+// confirmationPage asks the user to confirm what an emailed link does. Opening the link
+// only shows it, so mail scanners and link previews that fetch the link do not use up
+// its single-use token; the form posts the token back.
+var confirmationPage = template.Must(template.New("confirmation").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+</head>
+<body style="font-family: Helvetica, Arial, sans-serif; max-width: 420px; margin: 48px auto; text-align: center;">
+{{if .Token}}
+<p>{{.Prompt}}</p>
+<form method="post" action="{{.Action}}">
+<input type="hidden" name="token" value="{{.Token}}">
+{{if .CookieSession}}<input type="hidden" name="session" value="cookie">
+{{end}}<button type="submit">{{.Button}}</button>
+</form>
+{{else}}
+<p>{{.Invalid}}</p>
+{{end}}
+</body>
+</html>
+`))
+
+// confirmation fills the confirmation page of one kind of link. Without a Token the
+// page shows Invalid instead of the form.
+type confirmation struct {
+	Title   string
+	Prompt  string
+	Action  string
+	Button  string
+	Invalid string
+	Token   string
+	// CookieSession has the form ask for a cookie session, for links that log in
+	CookieSession bool
+}
+
+// renderConfirmation serves the confirmation page with status
+func renderConfirmation(c *gin.Context, status int, page confirmation) {
+	// the token must not leak through caches or the Referer header
+	c.Header("Cache-Control", "no-store")
+	c.Header("Referrer-Policy", "no-referrer")
+	c.Header("Content-Type", "text/html; charset=utf-8")
+	c.Status(status)
+	if err := confirmationPage.Execute(c.Writer, page); err != nil {
+		log.Println("Error rendering confirmation page: ", err)
+	}
+}
@@ -0,0 +1,68 @@
+package controllers
+
+import (
+	"context"
+	"go-ecommerce/database"
+	"go-ecommerce/events"
+	"go-ecommerce/models"
+	"go-ecommerce/notify"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var OutboxCollection *mongo.Collection = database.OutboxData(database.Client, "Outbox")
+
+// RegisterSubscribers subscribes the side effects of domain events, which the relay
+// runs once the change behind an event has been stored
+func (app *Application) RegisterSubscribers() {
+	events.Subscribe(models.EventOrderPlaced, "order-confirmation-email", func(ctx context.Context, event models.Event) error {
+		order, err := database.FindOrder(ctx, app.orderCollection, event.SubjectId, "")
+		if err != nil {
+			return err
+		}
+		return app.emailUser(ctx, order.UserId, notify.OrderConfirmation, notify.Data{Order: notify.NewOrder(*order)})
+	})
+	events.Subscribe(models.EventOrderCancelled, "cancellation-email", func(ctx context.Context, event models.Event) error {
+		order, err := database.FindOrder(ctx, app.orderCollection, event.SubjectId, "")
+		if err != nil {
+			return err
+		}
+		data := notify.Data{Order: notify.NewOrder(*order)}
+		if order.Cancellation != nil {
+			data.Reason = order.Cancellation.Reason
+		}
+		return app.emailUser(ctx, order.UserId, notify.OrderCancelled, data)
+	})
+	events.Subscribe(models.EventOrderCancelled, "cancellation-payment-reversal", func(ctx context.Context, event models.Event) error {
+		return app.reverseCharge(ctx, event.SubjectId)
+	})
+	events.Subscribe(models.EventShipmentSent, "shipment-email", func(ctx context.Context, event models.Event) error {
+		shipment, err := database.FindShipment(ctx, ShipmentCollection, event.SubjectId)
+		if err != nil {
+			return err
+		}
+		order, err := database.FindOrder(ctx, app.orderCollection, shipment.OrderId.Hex(), "")
+		if err != nil {
+			return err
+		}
+		return app.emailUser(ctx, order.UserId, notify.ShipmentSent, notify.Data{
+			Order:    notify.NewOrder(*order),
+			Shipment: notify.NewShipment(*shipment, *order),
+		})
+	})
+	events.Subscribe(models.EventRefundIssued, "refund-email", func(ctx context.Context, event models.Event) error {
+		entry, err := database.FindLedgerEntry(ctx, LedgerCollection, event.SubjectId)
+		if err != nil {
+			return err
+		}
+		// the cancellation email already tells the customer about their money
+		if entry.Source == models.RefundForCancellation {
+			return nil
+		}
+		order, err := database.FindOrder(ctx, app.orderCollection, entry.OrderId.Hex(), "")
+		if err != nil {
+			return err
+		}
+		return app.emailUser(ctx, order.UserId, notify.RefundIssued, notify.Data{Order: notify.NewOrder(*order), Refund: notify.NewRefund(*entry), Reason: entry.Reason})
+	})
+}
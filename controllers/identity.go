@@ -0,0 +1,61 @@
+package controllers
+
+import (
+	"context"
+	"go-ecommerce/database"
+	"go-ecommerce/models"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var AuditCollection *mongo.Collection = database.AuditData(database.Client, "AuditLog")
+
+// currentUserId returns the id of the authenticated user set by middleware.Authentication.
+// It aborts the request when there is none.
+func currentUserId(c *gin.Context) (string, bool) {
+	uid := c.GetString("uid")
+	if uid == "" {
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "not authenticated"})
+		return "", false
+	}
+	return uid, true
+}
+
+// targetUserId returns the id of the user whose cart or orders the request operates on.
+// Customer routes always resolve to the authenticated user. Admin routes name the
+// target explicitly in the :userId path parameter, and every such request is written
+// to the audit log before it is allowed to proceed.
+func targetUserId(c *gin.Context) (string, bool) {
+	actorId, ok := currentUserId(c)
+	if !ok {
+		return "", false
+	}
+
+	onBehalfOf := c.Param("userId")
+	if onBehalfOf == "" || onBehalfOf == actorId {
+		return actorId, true
+	}
+
+	if !models.HasPermission(c.GetString("role"), models.PermManageCarts) {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "not allowed to act on behalf of another user"})
+		return "", false
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	entry := models.AuditEntry{
+		ActorId:      actorId,
+		TargetUserId: onBehalfOf,
+		Action:       c.Request.Method + " " + c.FullPath(),
+		IP:           c.ClientIP(),
+	}
+	if err := database.RecordAudit(ctx, AuditCollection, entry); err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return onBehalfOf, true
+}
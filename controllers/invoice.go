@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"context"
+	"go-ecommerce/database"
+	"go-ecommerce/dto"
+	"go-ecommerce/invoice"
+	"go-ecommerce/models"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// InvoiceCollection keeps issued invoices. They are retained for accounting when an
+// account is erased.
+var InvoiceCollection *mongo.Collection = database.InvoiceData(database.Client, "Invoices")
+
+// issueInvoice issues the invoice of a paid order, billed to the user's default billing
+// address or else the first address the order shipped to. An order that already has an
+// invoice keeps it.
+func (app *Application) issueInvoice(ctx context.Context, order models.Order) (*models.Invoice, error) {
+	if order.InvoiceNumber != "" {
+		return database.FindInvoice(ctx, InvoiceCollection, order.InvoiceNumber)
+	}
+
+	user, err := database.FindUserById(ctx, app.userCollection, order.UserId)
+	if err != nil {
+		return nil, err
+	}
+	var billing models.Address
+	if len(order.Destinations) > 0 {
+		billing = order.Destinations[0].Address
+	}
+	for _, address := range user.AddressDetails {
+		if address.DefaultBilling {
+			billing = address
+		}
+	}
+
+	profile := dto.NewUserResponse(*user)
+	name := strings.TrimSpace(profile.FirstName + " " + profile.LastName)
+	draft := invoice.NewDraft(order, billing, name, time.Now())
+	return database.IssueInvoice(ctx, app.orderCollection, InvoiceCollection, draft)
+}
+
+// GetInvoice downloads the invoice of one of the user's paid orders as a PDF, or as
+// HTML with ?format=html
+func (app *Application) GetInvoice() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := targetUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		order, err := database.FindOrder(ctx, app.orderCollection, c.Param("orderId"), userId)
+		if err != nil {
+			c.JSON(orderStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if order.PaidAt == nil {
+			c.JSON(http.StatusConflict, gin.H{"error": database.ErrOrderNotInvoiceable.Error()})
+			return
+		}
+
+		issued, err := app.issueInvoice(ctx, *order)
+		if err != nil {
+			status := http.StatusInternalServerError
+			if err == database.ErrInvoicePending {
+				status = http.StatusServiceUnavailable
+				c.Header("Retry-After", "5")
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		filename := "invoice-" + issued.Number
+		if c.Query("format") == "html" {
+			c.Header("Content-Type", "text/html; charset=utf-8")
+			c.Status(http.StatusOK)
+			if err := invoice.HTML(c.Writer, *issued); err != nil {
+				log.Println("Error rendering invoice: ", err)
+			}
+			return
+		}
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.pdf"`)
+		c.Header("Content-Type", "application/pdf")
+		c.Status(http.StatusOK)
+		if err := invoice.PDF(c.Writer, *issued); err != nil {
+			log.Println("Error rendering invoice: ", err)
+		}
+	}
+}
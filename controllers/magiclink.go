@@ -0,0 +1,167 @@
+package controllers
+
+import (
+	"context"
+	"go-ecommerce/database"
+	"go-ecommerce/mailer"
+	"go-ecommerce/models"
+	generate "go-ecommerce/token"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// an address gets at most one login link a minute and five an hour
+const (
+	magicLinkInterval  = time.Minute
+	magicLinkHourlyCap = 5
+)
+
+// RequestMagicLink emails a single-use login link. The response never reveals whether
+// an account exists for the address, and requests over the rate limit are dropped
+// silently for the same reason.
+func RequestMagicLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		accepted := gin.H{"message": "If an account with that email exists, a login link has been sent"}
+
+		var foundUser models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"email": body.Email}).Decode(&foundUser); err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Println(err)
+			}
+			c.JSON(http.StatusAccepted, accepted)
+			return
+		}
+
+		recent, err := database.CountRecentActionTokens(ctx, ActionTokenCollection, foundUser.UserId, models.PurposeMagicLink, time.Now().Add(-magicLinkInterval))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		hourly, err := database.CountRecentActionTokens(ctx, ActionTokenCollection, foundUser.UserId, models.PurposeMagicLink, time.Now().Add(-time.Hour))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if recent > 0 || hourly >= magicLinkHourlyCap {
+			c.JSON(http.StatusAccepted, accepted)
+			return
+		}
+
+		// the action token makes the link single-use; issuing it invalidates earlier links
+		tokenId, err := database.CreateActionToken(ctx, ActionTokenCollection, foundUser.UserId, models.PurposeMagicLink, generate.MagicLinkLifetime)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		magicToken, err := generate.MagicLinkGenerator(foundUser.UserId, tokenId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		link := appURL("/users/login/magic/verify", url.Values{"token": {magicToken}})
+		msg := mailer.Message{
+			To:      body.Email,
+			UserId:  foundUser.UserId,
+			Subject: "Your login link",
+			Text:    "Use the link below to log in. It expires in 15 minutes and can only be used once.\n\n" + link,
+		}
+		if err := Mailer.Send(ctx, msg); err != nil {
+			log.Println("Error sending login link: ", err)
+		}
+		c.JSON(http.StatusAccepted, accepted)
+	}
+}
+
+// ConfirmMagicLink serves the page a login link opens. The token is checked but not
+// used; the page posts it to RedeemMagicLink.
+func ConfirmMagicLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page := confirmation{
+			Title:         "Log in",
+			Prompt:        "Continue to log in to your account.",
+			Action:        "/users/login/magic/verify",
+			Button:        "Log in",
+			Invalid:       "This login link is invalid or has expired. Request a new one to log in.",
+			Token:         c.Query("token"),
+			CookieSession: true,
+		}
+		status := http.StatusOK
+		if _, msg := generate.ValidateToken(page.Token, generate.MagicLinkToken); page.Token == "" || msg != "" {
+			page.Token, status = "", http.StatusUnauthorized
+		}
+		renderConfirmation(c, status, page)
+	}
+}
+
+// RedeemMagicLink logs the user in with a token from a login link, sent as JSON or a
+// form field. Receiving the link also proves ownership of the address, so an unverified
+// email becomes verified.
+func RedeemMagicLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Token   string `json:"token" form:"token" validate:"required"`
+			Session string `json:"session" form:"session"`
+		}
+		if err := c.ShouldBind(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+
+		claims, msg := generate.ValidateToken(body.Token, generate.MagicLinkToken)
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		consumed, err := database.ConsumeActionToken(ctx, ActionTokenCollection, claims.Id, models.PurposeMagicLink)
+		if err != nil || consumed.UserId != claims.Uid {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": database.ErrInvalidActionToken.Error()})
+			return
+		}
+
+		foundUser, err := database.FindUserById(ctx, UserCollection, claims.Uid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if !foundUser.EmailVerified {
+			if err := database.MarkEmailVerified(ctx, UserCollection, foundUser.UserId); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			foundUser.EmailVerified = true
+		}
+
+		cookieSession := body.Session == "cookie" || c.Query("session") == "cookie"
+		firstFactorPassed(ctx, c, *foundUser, cookieSession)
+	}
+}
@@ -0,0 +1,206 @@
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"go-ecommerce/database"
+	generate "go-ecommerce/token"
+	"go-ecommerce/totp"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	totpIssuer        = "go-ecommerce"
+	totpSkew          = 1
+	recoveryCodeCount = 10
+)
+
+// newRecoveryCodes returns recovery codes to show the user once, and the hashes to store
+func newRecoveryCodes() ([]string, []string, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 6)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+		encoded := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(raw))
+		code := encoded[:5] + "-" + encoded[5:10]
+		codes = append(codes, code)
+		hashes = append(hashes, hashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
+
+func hashRecoveryCode(code string) string {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// EnrollTOTP creates a new secret for the authenticated user. It only becomes active once
+// a code generated from it is confirmed through VerifyTOTP.
+func EnrollTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		foundUser, err := database.FindUserById(ctx, UserCollection, userId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if foundUser.TOTPEnabled {
+			c.JSON(http.StatusConflict, gin.H{"error": "two-factor authentication is already enabled"})
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		encoded := totp.EncodeSecret(secret)
+		if err := database.SetPendingTOTPSecret(ctx, UserCollection, userId, encoded); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":     encoded,
+			"otpauthUri": totp.URI(totpIssuer, *foundUser.Email, secret, totp.DefaultOptions),
+		})
+	}
+}
+
+// VerifyTOTP confirms enrollment with a first code and returns the recovery codes
+func VerifyTOTP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			Code string `json:"code" validate:"required,numeric"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		foundUser, err := database.FindUserById(ctx, UserCollection, userId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if foundUser.TOTPPendingSecret == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "no two-factor enrollment in progress"})
+			return
+		}
+
+		secret, err := totp.DecodeSecret(*foundUser.TOTPPendingSecret)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		step, valid := totp.Validate(secret, body.Code, time.Now(), totp.DefaultOptions, totpSkew)
+		if !valid {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.EnableTOTP(ctx, UserCollection, userId, *foundUser.TOTPPendingSecret, step, hashes); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"recoveryCodes": codes})
+	}
+}
+
+// LoginMFA is the second step of a two-factor login. It exchanges the challenge token
+// from Login and either a current code or an unused recovery code for a token pair.
+func LoginMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			MFAToken     string `json:"mfaToken" validate:"required"`
+			Code         string `json:"code" validate:"required_without=RecoveryCode"`
+			RecoveryCode string `json:"recoveryCode"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims, msg := generate.ValidateToken(body.MFAToken, generate.MFAChallengeToken)
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		foundUser, err := database.FindUserById(ctx, UserCollection, claims.Uid)
+		if err != nil || !foundUser.TOTPEnabled || foundUser.TOTPSecret == nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid code"})
+			return
+		}
+
+		// wrong codes count towards the same lockout as wrong passwords
+		if loginLocked(ctx, c, *foundUser.Email) {
+			return
+		}
+
+		if body.Code != "" {
+			secret, err := totp.DecodeSecret(*foundUser.TOTPSecret)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			step, valid := totp.Validate(secret, body.Code, time.Now(), totp.DefaultOptions, totpSkew)
+			if !valid {
+				loginFailed(ctx, c, *foundUser.Email, foundUser.UserId)
+				return
+			}
+			if err := database.RecordTOTPStep(ctx, UserCollection, foundUser.UserId, step); err != nil {
+				c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+				return
+			}
+		} else if err := database.UseRecoveryCode(ctx, UserCollection, foundUser.UserId, hashRecoveryCode(body.RecoveryCode)); err != nil {
+			loginFailed(ctx, c, *foundUser.Email, foundUser.UserId)
+			return
+		}
+		loginSucceeded(ctx, *foundUser.Email)
+
+		completeLogin(c, *foundUser, c.Query("session") == "cookie")
+	}
+}
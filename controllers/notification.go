@@ -0,0 +1,56 @@
+package controllers
+
+import (
+	"context"
+	"go-ecommerce/database"
+	"go-ecommerce/mailer"
+	"go-ecommerce/models"
+	"go-ecommerce/notify"
+	"log"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var DeliveryCollection *mongo.Collection = database.DeliveryData(database.Client, "EmailDeliveries")
+
+// logDeliveries writes the outcome of every email sent through m to the delivery log
+func logDeliveries(m *mailer.Retrying) *mailer.Retrying {
+	m.OnDelivery = func(ctx context.Context, delivery mailer.Delivery) {
+		entry := models.EmailDelivery{
+			UserId:    delivery.UserId,
+			To:        delivery.To,
+			Subject:   delivery.Subject,
+			Template:  delivery.Tag,
+			Status:    delivery.Status,
+			Attempts:  delivery.Attempts,
+			Error:     delivery.Error,
+			CreatedAt: delivery.At,
+		}
+		if err := database.RecordDelivery(ctx, DeliveryCollection, entry); err != nil {
+			log.Println("Error logging email delivery: ", err)
+		}
+	}
+	return m
+}
+
+// emailUser sends the user the named message in their language
+func (app *Application) emailUser(ctx context.Context, userId, name string, data notify.Data) error {
+	foundUser, err := database.FindUserById(ctx, app.userCollection, userId)
+	if err != nil {
+		return err
+	}
+	// erased accounts keep their orders but have no address left
+	if foundUser.Email == nil || foundUser.DeletedAt != nil {
+		return nil
+	}
+	return sendNotification(ctx, notify.RecipientOf(*foundUser), name, data)
+}
+
+// sendNotification renders the named message for to and sends it
+func sendNotification(ctx context.Context, to notify.Recipient, name string, data notify.Data) error {
+	msg, err := notify.Compose(to, name, data)
+	if err != nil {
+		return err
+	}
+	return Mailer.Send(ctx, msg)
+}
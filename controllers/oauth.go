@@ -0,0 +1,234 @@
+package controllers
+
+import (
+	"context"
+	"crypto/subtle"
+	"go-ecommerce/database"
+	"go-ecommerce/models"
+	"go-ecommerce/oidc"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	oauthStateTTL = 10 * time.Minute
+	// oauthStateCookie binds a sign in to the browser that started it; it holds a hash
+	// of the state and is only sent to the callback
+	oauthStateCookie     = "oauth_state"
+	oauthStateCookiePath = "/users/oauth"
+)
+
+var (
+	OAuthStateCollection *mongo.Collection = database.OAuthStateData(database.Client, "OAuthStates")
+	OIDCProviders                          = oidc.ProvidersFromEnv()
+)
+
+// OAuthStart redirects the browser to the sign in page of the provider named in the path
+func OAuthStart() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, ok := OIDCProviders[c.Param("provider")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": oidc.ErrUnknownProvider.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		var values [3]string
+		for i := range values {
+			value, err := oidc.RandomString()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			values[i] = value
+		}
+		state, nonce, codeVerifier := values[0], values[1], values[2]
+
+		authURL, err := provider.AuthCodeURL(ctx, state, nonce, codeVerifier)
+		if err != nil {
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+			return
+		}
+
+		pending := models.OAuthState{
+			Provider:      provider.Name,
+			Nonce:         nonce,
+			CodeVerifier:  codeVerifier,
+			CookieSession: c.Query("session") == "cookie",
+			ExpiresAt:     time.Now().Add(oauthStateTTL),
+		}
+		if err := database.SaveOAuthState(ctx, OAuthStateCollection, state, pending); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// Lax, since the provider sends the browser back with a cross-site redirect. The
+		// cookie holds a hash of the state, so the cookie alone cannot complete a sign in.
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(oauthStateCookie, database.HashActionToken(state), int(oauthStateTTL.Seconds()), oauthStateCookiePath, "", true, true)
+		c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+// OAuthCallback completes a provider login. The external identity is matched to a user
+// by the link made on an earlier login, then by verified email, and a new account is
+// created when neither exists. The user then gets our own tokens like any other login.
+func OAuthCallback() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		provider, ok := OIDCProviders[c.Param("provider")]
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": oidc.ErrUnknownProvider.Error()})
+			return
+		}
+		if errCode := c.Query("error"); errCode != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "sign in was not completed: " + errCode})
+			return
+		}
+		state, code := c.Query("state"), c.Query("code")
+		if state == "" || code == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "state and code are required"})
+			return
+		}
+
+		// a callback URL from a sign in started elsewhere, e.g. sent by an attacker to log
+		// the victim into the attacker's account, is refused before the state is used
+		bound, err := c.Cookie(oauthStateCookie)
+		if err != nil || subtle.ConstantTimeCompare([]byte(bound), []byte(database.HashActionToken(state))) != 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the sign in was not started in this browser"})
+			return
+		}
+		c.SetSameSite(http.SameSiteLaxMode)
+		c.SetCookie(oauthStateCookie, "", -1, oauthStateCookiePath, "", true, true)
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 20*time.Second)
+		defer cancel()
+
+		pending, err := database.ConsumeOAuthState(ctx, OAuthStateCollection, state, provider.Name)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims, err := provider.Exchange(ctx, code, pending.CodeVerifier, pending.Nonce)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "could not verify the sign in with " + provider.Name})
+			return
+		}
+
+		foundUser, err := userForIdentity(ctx, c, provider.Name, claims)
+		if err != nil {
+			return
+		}
+		firstFactorPassed(ctx, c, *foundUser, pending.CookieSession)
+	}
+}
+
+// userForIdentity resolves the verified provider claims to a user, linking or creating
+// one as needed. It writes the error response itself when it fails.
+func userForIdentity(ctx context.Context, c *gin.Context, provider string, claims *oidc.Claims) (*models.User, error) {
+	foundUser, err := database.FindUserByIdentity(ctx, UserCollection, provider, claims.Subject)
+	if err == nil {
+		return foundUser, nil
+	}
+	if err != database.ErrUserNotFound {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, err
+	}
+
+	// without a verified email the identity cannot be matched to or create an account
+	if claims.Email == "" || !claims.EmailVerified {
+		c.JSON(http.StatusForbidden, gin.H{"error": "the provider did not supply a verified email address"})
+		return nil, database.ErrUserNotFound
+	}
+
+	identity := models.ExternalIdentity{
+		Provider: provider,
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+		LinkedAt: time.Now(),
+	}
+
+	var existing models.User
+	err = UserCollection.FindOne(ctx, bson.M{"email": claims.Email}).Decode(&existing)
+	switch {
+	case err == mongo.ErrNoDocuments:
+		return createIdentityUser(ctx, c, claims, identity)
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return nil, err
+	}
+
+	if !existing.EmailVerified {
+		if err := database.ClaimUnverifiedAccount(ctx, UserCollection, existing.UserId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return nil, err
+		}
+		if err := database.RevokeAllSessions(ctx, SessionCollection, existing.UserId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return nil, err
+		}
+		existing.EmailVerified, existing.Password = true, nil
+	}
+	if err := database.LinkIdentity(ctx, UserCollection, existing.UserId, identity); err != nil {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return nil, err
+	}
+
+	entry := models.AuditEntry{
+		ActorId:      existing.UserId,
+		TargetUserId: existing.UserId,
+		Action:       "linked " + provider + " identity " + claims.Subject,
+		IP:           c.ClientIP(),
+	}
+	if err := database.RecordAudit(ctx, AuditCollection, entry); err != nil {
+		log.Println("Error auditing identity link: ", err)
+	}
+	return &existing, nil
+}
+
+// createIdentityUser signs up a new customer from provider claims. The account has no
+// password; it is only reachable through the provider until one is set.
+func createIdentityUser(ctx context.Context, c *gin.Context, claims *oidc.Claims, identity models.ExternalIdentity) (*models.User, error) {
+	firstName, lastName := claims.GivenName, claims.FamilyName
+	if firstName == "" {
+		firstName = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+
+	now := time.Now()
+	user := models.User{
+		ID:              primitive.NewObjectID(),
+		FirstName:       &firstName,
+		LastName:        &lastName,
+		Email:           &claims.Email,
+		CreatedAt:       now,
+		UpdatedAt:       now,
+		UserCart:        make([]models.UserProduct, 0),
+		AddressDetails:  make([]models.Address, 0),
+		OrderStatus:     make([]models.Order, 0),
+		Role:            models.RoleCustomer,
+		EmailVerified:   true,
+		EmailVerifiedAt: &now,
+		Identities:      []models.ExternalIdentity{identity},
+	}
+	user.UserId = user.ID.Hex()
+
+	err := database.WithEvents(ctx, OutboxCollection, func(ctx context.Context) error {
+		_, err := UserCollection.InsertOne(ctx, user)
+		return err
+	}, models.NewEvent(models.EventUserSignedUp, user.UserId, user.UserId))
+	if err != nil {
+		log.Println("Error in creating user: ", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "The user did not get created"})
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,355 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"go-ecommerce/database"
+	"go-ecommerce/dto"
+	"go-ecommerce/models"
+	"go-ecommerce/payment"
+	"go-ecommerce/postal"
+	"go-ecommerce/shipping"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	OrderCollection *mongo.Collection = database.OrderData(database.Client, "Orders")
+	ShippingQuoter  shipping.Quoter   = shipping.DefaultRates()
+	// PaymentProvider takes digital payments. main sets it from PAYMENT_PROVIDER; while
+	// it is nil only cash on delivery is accepted.
+	PaymentProvider payment.Provider
+)
+
+// Checkout places an order for the cart. Its items can be split across several
+// addresses of the address book; each becomes a destination with its own shipping
+// quote, and the order is paid with a single charge.
+func (app *Application) Checkout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := targetUserId(c)
+		if !ok {
+			return
+		}
+
+		var req dto.CheckoutRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		app.checkoutCart(ctx, c, userId, req)
+	}
+}
+
+func (app *Application) checkoutCart(ctx context.Context, c *gin.Context, userId string, req dto.CheckoutRequest) {
+	if !app.requireVerifiedEmail(ctx, c, userId) {
+		return
+	}
+
+	cart, err := database.CartItems(ctx, app.userCollection, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	order, ok := app.planOrder(ctx, c, userId, cart, req)
+	if !ok {
+		return
+	}
+	app.placeOrder(ctx, c, order, req, func(ctx context.Context, order models.Order) error {
+		return database.BuyItemFromCart(ctx, app.userCollection, app.orderCollection, userId, cart, order)
+	}, models.NewEvent(models.EventCartUpdated, userId, userId))
+}
+
+// cartLine is one product of the cart with the number of times it was added
+type cartLine struct {
+	product  models.UserProduct
+	quantity int
+	assigned int
+}
+
+// planOrder turns the cart into an order with line items assigned to destinations as
+// requested, or all to ?addressId or the default shipping address when the request
+// names none. Every problem is reported per field with 422.
+func (app *Application) planOrder(ctx context.Context, c *gin.Context, userId string, cart []models.UserProduct, req dto.CheckoutRequest) (models.Order, bool) {
+	invalid := &postal.ValidationError{}
+	fail := func(field, message string) {
+		invalid.Fields = append(invalid.Fields, postal.FieldError{Field: field, Message: message})
+	}
+
+	lines := make(map[primitive.ObjectID]*cartLine)
+	productIds := make([]primitive.ObjectID, 0)
+	for _, product := range cart {
+		if line, ok := lines[product.ProductId]; ok {
+			line.quantity++
+			continue
+		}
+		lines[product.ProductId] = &cartLine{product: product, quantity: 1}
+		productIds = append(productIds, product.ProductId)
+	}
+	if len(productIds) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "the cart is empty"})
+		return models.Order{}, false
+	}
+
+	addresses, err := database.ListAddresses(ctx, app.userCollection, userId)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return models.Order{}, false
+	}
+
+	destinations := req.Destinations
+	if len(destinations) == 0 {
+		addressId := c.Query("addressId")
+		for _, address := range addresses {
+			if addressId == "" && address.DefaultShipping {
+				addressId = address.AddressId.Hex()
+			}
+		}
+		if addressId == "" {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "address is not deliverable", "fields": []postal.FieldError{{Field: "address", Message: "add a shipping address before checking out"}}})
+			return models.Order{}, false
+		}
+		items := make([]dto.ItemRequest, 0, len(productIds))
+		for _, productId := range productIds {
+			items = append(items, dto.ItemRequest{ProductId: productId.Hex(), Quantity: lines[productId].quantity})
+		}
+		destinations = []dto.DestinationRequest{{AddressId: addressId, Items: items}}
+	}
+
+	order := models.Order{
+		OrderId:      primitive.NewObjectID(),
+		UserId:       userId,
+		Status:       models.OrderPlaced,
+		OrderedAt:    time.Now(),
+		LineItems:    make([]models.LineItem, 0),
+		Destinations: make([]models.Destination, 0, len(destinations)),
+	}
+
+	usedAddresses := make(map[string]bool)
+	for i, requested := range destinations {
+		prefix := fmt.Sprintf("destinations[%d]", i)
+		if usedAddresses[requested.AddressId] {
+			fail(prefix+".addressId", "is used by another destination, merge their items")
+			continue
+		}
+		usedAddresses[requested.AddressId] = true
+
+		var address *models.Address
+		for j := range addresses {
+			if addresses[j].AddressId.Hex() == requested.AddressId {
+				address = &addresses[j]
+			}
+		}
+		if address == nil {
+			fail(prefix+".addressId", database.ErrAddressNotFound.Error())
+			continue
+		}
+		normalized, err := postal.Normalize(*address)
+		if invalidAddress, ok := err.(*postal.ValidationError); ok {
+			for _, field := range invalidAddress.Fields {
+				fail(prefix+".address."+field.Field, field.Message)
+			}
+			continue
+		}
+
+		destination := models.Destination{DestinationId: primitive.NewObjectID(), Address: normalized}
+		for j, item := range requested.Items {
+			productId, err := primitive.ObjectIDFromHex(item.ProductId)
+			line, inCart := lines[productId]
+			if err != nil || !inCart {
+				fail(fmt.Sprintf("%s.items[%d].productId", prefix, j), "is not in the cart")
+				continue
+			}
+			line.assigned += item.Quantity
+			order.LineItems = append(order.LineItems, models.LineItem{
+				LineId:        primitive.NewObjectID(),
+				ProductId:     productId,
+				ProductName:   line.product.ProductName,
+				Price:         line.product.Price,
+				Quantity:      item.Quantity,
+				DestinationId: destination.DestinationId,
+			})
+		}
+		order.Destinations = append(order.Destinations, destination)
+	}
+
+	// every item in the cart ships somewhere, and nothing ships that is not in it
+	for _, productId := range productIds {
+		line := lines[productId]
+		if line.assigned != line.quantity {
+			name := productId.Hex()
+			if line.product.ProductName != nil {
+				name = *line.product.ProductName
+			}
+			fail("destinations", fmt.Sprintf("%d of %d %s assigned", line.assigned, line.quantity, name))
+		}
+	}
+
+	if len(invalid.Fields) > 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": invalid.Error(), "fields": invalid.Fields})
+		return models.Order{}, false
+	}
+
+	for i := range order.Destinations {
+		destination := &order.Destinations[i]
+		items := make([]models.LineItem, 0)
+		for _, line := range order.LineItems {
+			if line.DestinationId == destination.DestinationId {
+				items = append(items, line)
+				order.ItemsTotal += line.Price * line.Quantity
+			}
+		}
+		if destination.Quote, err = ShippingQuoter.Quote(ctx, destination.Address, items); err != nil {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+			return models.Order{}, false
+		}
+		order.ShippingTotal += destination.Quote.Amount
+	}
+	order.Price = order.ItemsTotal + order.ShippingTotal
+	return order, true
+}
+
+// placeOrder reserves stock for order, takes payment and stores it with place, together
+// with its OrderPlaced and, when paid already, OrderPaid events and any further events
+// of the change. The stock and a digital payment are given back if a later step fails.
+func (app *Application) placeOrder(ctx context.Context, c *gin.Context, order models.Order, req dto.CheckoutRequest, place func(context.Context, models.Order) error, events ...models.Event) {
+	if req.PaymentMethod == dto.PaymentDigital && PaymentProvider == nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": payment.ErrNoProvider.Error()})
+		return
+	}
+	if err := database.ReserveStock(ctx, app.prodCollection, OutboxCollection, order.LineItems); err != nil {
+		status := http.StatusInternalServerError
+		if err == database.ErrOutOfStock {
+			status = http.StatusConflict
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	releaseStock := func() {
+		if err := database.ReleaseStock(ctx, app.prodCollection, OutboxCollection, order.LineItems); err != nil {
+			log.Printf("Error releasing stock of unplaced order %s: %v", order.OrderId.Hex(), err)
+		}
+	}
+
+	if req.PaymentMethod == dto.PaymentDigital {
+		order.PaymentMethod.Digital = true
+		transactionId, err := PaymentProvider.Charge(ctx, payment.ChargeRequest{
+			Amount:    order.Price,
+			Currency:  payment.Currency(),
+			Reference: order.OrderId.Hex(),
+			Token:     req.PaymentToken,
+		})
+		if err != nil {
+			releaseStock()
+			c.JSON(http.StatusPaymentRequired, gin.H{"error": err.Error()})
+			return
+		}
+		now := time.Now()
+		order.Charge = &models.Charge{
+			Provider:      PaymentProvider.Name(),
+			TransactionId: transactionId,
+			Amount:        order.Price,
+			Currency:      payment.Currency(),
+			Status:        models.ChargeCaptured,
+			CreatedAt:     now,
+		}
+		order.PaidAt = &now
+	} else {
+		order.PaymentMethod.COD = true
+	}
+
+	events = append(events, models.NewEvent(models.EventOrderPlaced, order.OrderId.Hex(), order.UserId))
+	if order.PaidAt != nil {
+		events = append(events, models.NewEvent(models.EventOrderPaid, order.OrderId.Hex(), order.UserId))
+	}
+	err := database.WithEvents(ctx, OutboxCollection, func(ctx context.Context) error {
+		return place(ctx, order)
+	}, events...)
+	if err != nil {
+		releaseStock()
+		if order.Charge != nil {
+			if voidErr := PaymentProvider.Void(ctx, order.Charge.TransactionId); voidErr != nil {
+				log.Printf("Error voiding charge %s of unplaced order %s: %v", order.Charge.TransactionId, order.OrderId.Hex(), voidErr)
+			}
+		}
+		status := http.StatusConflict
+		if err != database.ErrCartChanged {
+			log.Println(err)
+			status, err = http.StatusInternalServerError, database.ErrCantBuyCartItem
+		}
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+	if order.Charge != nil {
+		recordPayment(ctx, order, models.LedgerCharge, order.UserId)
+		if issued, err := app.issueInvoice(ctx, order); err != nil {
+			// it is issued when the invoice is first downloaded instead
+			log.Printf("Error issuing the invoice of order %s: %v", order.OrderId.Hex(), err)
+		} else {
+			order.InvoiceNumber = issued.Number
+		}
+	}
+	c.JSON(http.StatusCreated, dto.NewOrderResponse(order))
+}
+
+// ListOrders returns the orders of the user, newest first
+func (app *Application) ListOrders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := targetUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		orders, err := database.ListOrders(ctx, app.orderCollection, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, dto.NewOrderResponses(orders))
+	}
+}
+
+func (app *Application) GetOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := targetUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		order, err := database.FindOrder(ctx, app.orderCollection, c.Param("orderId"), userId)
+		if err != nil {
+			c.JSON(orderStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, dto.NewOrderResponse(*order))
+	}
+}
+
+// orderStatus maps order lookup errors to response codes
+func orderStatus(err error) int {
+	switch err {
+	case database.ErrOrderNotFound:
+		return http.StatusNotFound
+	case database.ErrOrderIdIsNotValid:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,124 @@
+package controllers
+
+import (
+	"context"
+	"go-ecommerce/database"
+	"go-ecommerce/mailer"
+	"go-ecommerce/models"
+	"go-ecommerce/notify"
+	"go-ecommerce/passwords"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const passwordResetTTL = time.Hour
+
+var (
+	ActionTokenCollection *mongo.Collection = database.ActionTokenData(database.Client, "ActionTokens")
+	Mailer                mailer.Mailer     = logDeliveries(mailer.FromEnv())
+)
+
+// appURL builds a link into the application for use in emails
+func appURL(path string, query url.Values) string {
+	base := os.Getenv("APP_BASE_URL")
+	if base == "" {
+		base = "http://localhost:8000"
+	}
+	return base + path + "?" + query.Encode()
+}
+
+func ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Email string `json:"email" validate:"required,email"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		// the response is the same whether or not the account exists
+		accepted := gin.H{"message": "If an account with that email exists, a reset link has been sent"}
+
+		var foundUser models.User
+		if err := UserCollection.FindOne(ctx, bson.M{"email": body.Email}).Decode(&foundUser); err != nil {
+			if err != mongo.ErrNoDocuments {
+				log.Println(err)
+			}
+			c.JSON(http.StatusAccepted, accepted)
+			return
+		}
+
+		resetToken, err := database.CreateActionToken(ctx, ActionTokenCollection, foundUser.UserId, models.PurposePasswordReset, passwordResetTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		link := appURL("/users/password/reset", url.Values{"token": {resetToken}})
+		if err := sendNotification(ctx, notify.RecipientOf(foundUser), notify.PasswordReset, notify.Data{Link: link}); err != nil {
+			log.Println("Error sending password reset email: ", err)
+		}
+		c.JSON(http.StatusAccepted, accepted)
+	}
+}
+
+func ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Token    string `json:"token" validate:"required"`
+			Password string `json:"password" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := passwords.DefaultPolicy.Check(body.Password); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		passwordHash, err := HashPassword(body.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		resetToken, err := database.ConsumeActionToken(ctx, ActionTokenCollection, body.Token, models.PurposePasswordReset)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := database.UpdatePassword(ctx, UserCollection, resetToken.UserId, passwordHash); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.RevokeAllSessions(ctx, SessionCollection, resetToken.UserId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Successfully reset the password")
+	}
+}
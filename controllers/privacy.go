@@ -0,0 +1,352 @@
+package controllers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"go-ecommerce/database"
+	"go-ecommerce/dto"
+	"go-ecommerce/mailer"
+	"go-ecommerce/middleware"
+	"go-ecommerce/models"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const defaultDeletionGracePeriod = 30 * 24 * time.Hour
+
+// DeletionGracePeriod reads ACCOUNT_DELETION_GRACE, e.g. "336h", the time between a
+// deletion request and the erasure during which it can still be cancelled
+func DeletionGracePeriod() time.Duration {
+	value := strings.TrimSpace(os.Getenv("ACCOUNT_DELETION_GRACE"))
+	if value == "" {
+		return defaultDeletionGracePeriod
+	}
+	grace, err := time.ParseDuration(value)
+	if err != nil || grace < 0 {
+		log.Printf("invalid ACCOUNT_DELETION_GRACE %q, using the default", value)
+		return defaultDeletionGracePeriod
+	}
+	return grace
+}
+
+// dataExport is everything stored about a user, as handed out by ExportData
+type dataExport struct {
+	ExportedAt time.Time             `json:"exportedAt"`
+	Profile    dto.UserResponse      `json:"profile"`
+	Addresses  []dto.AddressResponse `json:"addresses"`
+	Cart       []models.UserProduct  `json:"cart"`
+	Orders     []dto.OrderResponse   `json:"orders"`
+	// LegacyOrders are orders placed before they were kept in their own collection
+	LegacyOrders []models.Order        `json:"legacyOrders,omitempty"`
+	Sessions     []dto.SessionResponse `json:"sessions"`
+}
+
+// ExportData hands the authenticated user a copy of their data, as one JSON document by
+// default or as a ZIP archive with one file per section when ?format=zip
+func ExportData() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		foundUser, err := database.FindUserById(ctx, UserCollection, userId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		sessions, err := database.ListSessions(ctx, SessionCollection, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		orders, err := database.ListOrders(ctx, OrderCollection, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		export := dataExport{
+			ExportedAt:   time.Now(),
+			Profile:      dto.NewUserResponse(*foundUser),
+			Addresses:    dto.NewAddressResponses(foundUser.AddressDetails),
+			Cart:         foundUser.UserCart,
+			Orders:       dto.NewOrderResponses(orders),
+			LegacyOrders: foundUser.OrderStatus,
+			Sessions:     dto.NewSessionResponses(sessions, c.GetString("sid")),
+		}
+		filename := "export-" + userId + "-" + export.ExportedAt.Format("20060102")
+
+		if c.Query("format") != "zip" {
+			c.Header("Content-Disposition", `attachment; filename="`+filename+`.json"`)
+			c.JSON(http.StatusOK, export)
+			return
+		}
+
+		c.Header("Content-Disposition", `attachment; filename="`+filename+`.zip"`)
+		c.Status(http.StatusOK)
+		c.Header("Content-Type", "application/zip")
+		archive := zip.NewWriter(c.Writer)
+		// a slice rather than a map, so every download lists the files in the same order
+		sections := []struct {
+			name    string
+			content interface{}
+		}{
+			{"profile.json", export.Profile},
+			{"addresses.json", export.Addresses},
+			{"cart.json", export.Cart},
+			{"orders.json", export.Orders},
+			{"legacy_orders.json", export.LegacyOrders},
+			{"sessions.json", export.Sessions},
+		}
+		for _, section := range sections {
+			file, err := archive.Create(section.name)
+			if err != nil {
+				log.Println("Error writing data export: ", err)
+				return
+			}
+			encoder := json.NewEncoder(file)
+			encoder.SetIndent("", "  ")
+			if err := encoder.Encode(section.content); err != nil {
+				log.Println("Error writing data export: ", err)
+				return
+			}
+		}
+		if err := archive.Close(); err != nil {
+			log.Println("Error writing data export: ", err)
+		}
+	}
+}
+
+// DeleteAccount schedules the authenticated user's account for erasure after the grace
+// period and signs it out everywhere. Logging in again and cancelling keeps the account.
+func DeleteAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			CurrentPassword string `json:"currentPassword"`
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.BindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		foundUser, err := database.FindUserById(ctx, UserCollection, userId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		// accounts with a password must confirm with it; provider-only accounts cannot
+		if foundUser.Password != nil {
+			if _, ok := checkCurrentPassword(ctx, c, userId, body.CurrentPassword); !ok {
+				return
+			}
+		}
+
+		dueAt := time.Now().Add(DeletionGracePeriod())
+		if err := database.ScheduleDeletion(ctx, UserCollection, userId, dueAt); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.RevokeAllSessions(ctx, SessionCollection, userId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		middleware.ClearSessionCookies(c)
+
+		entry := models.AuditEntry{
+			ActorId:      userId,
+			TargetUserId: userId,
+			Action:       "scheduled account deletion for " + dueAt.Format(time.RFC3339),
+			IP:           c.ClientIP(),
+		}
+		if err := database.RecordAudit(ctx, AuditCollection, entry); err != nil {
+			log.Println("Error auditing account deletion: ", err)
+		}
+
+		msg := mailer.Message{
+			To:      *foundUser.Email,
+			UserId:  userId,
+			Subject: "Your account will be deleted",
+			Text: "Your account and personal data will be deleted on " + dueAt.Format("2 January 2006") +
+				". To keep your account, log in before then and cancel the deletion.",
+		}
+		if err := Mailer.Send(ctx, msg); err != nil {
+			log.Println("Error sending deletion notice: ", err)
+		}
+		c.JSON(http.StatusAccepted, gin.H{"deletionDueAt": dueAt})
+	}
+}
+
+// CancelAccountDeletion keeps the authenticated user's account
+func CancelAccountDeletion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := database.CancelDeletion(ctx, UserCollection, userId); err != nil {
+			status := http.StatusInternalServerError
+			if err == database.ErrNoDeletionScheduled {
+				status = http.StatusNotFound
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Successfully cancelled the account deletion")
+	}
+}
+
+// AdminEraseUser erases an account immediately, skipping the grace period
+func AdminEraseUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		userId := c.Param("userId")
+		entry := models.AuditEntry{
+			ActorId:      c.GetString("uid"),
+			TargetUserId: userId,
+			Action:       "erased account",
+			IP:           c.ClientIP(),
+		}
+		if err := database.RecordAudit(ctx, AuditCollection, entry); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := eraseAccount(ctx, userId); err != nil {
+			status := http.StatusInternalServerError
+			if err == database.ErrUserNotFound {
+				status = http.StatusNotFound
+			} else if err == database.ErrUserIdIsNotValid {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Successfully erased the account")
+	}
+}
+
+// AdminCancelDeletion stops a scheduled deletion on the user's behalf
+func AdminCancelDeletion() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		userId := c.Param("userId")
+		entry := models.AuditEntry{
+			ActorId:      c.GetString("uid"),
+			TargetUserId: userId,
+			Action:       "cancelled account deletion",
+			IP:           c.ClientIP(),
+		}
+		if err := database.RecordAudit(ctx, AuditCollection, entry); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := database.CancelDeletion(ctx, UserCollection, userId); err != nil {
+			status := http.StatusInternalServerError
+			if err == database.ErrNoDeletionScheduled {
+				status = http.StatusNotFound
+			} else if err == database.ErrUserIdIsNotValid {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Successfully cancelled the account deletion")
+	}
+}
+
+// eraseAccount deletes the sessions, tokens, login attempts and email delivery log of
+// the user and strips the user document, their orders and their invoices down to what
+// must be retained for accounting
+func eraseAccount(ctx context.Context, userId string) error {
+	foundUser, err := database.FindUserById(ctx, UserCollection, userId)
+	if err != nil {
+		return err
+	}
+	if foundUser.DeletedAt != nil {
+		return nil
+	}
+
+	if err := database.DeleteSessions(ctx, SessionCollection, userId); err != nil {
+		return err
+	}
+	if err := database.DeleteActionTokens(ctx, ActionTokenCollection, userId); err != nil {
+		return err
+	}
+	if foundUser.Email != nil {
+		if err := database.ClearLoginFailures(ctx, LoginAttemptCollection, accountKey(*foundUser.Email)); err != nil {
+			return err
+		}
+	}
+	addresses := make([]string, 0, 2)
+	for _, address := range []*string{foundUser.Email, foundUser.PendingEmail} {
+		if address != nil {
+			addresses = append(addresses, *address)
+		}
+	}
+	if err := database.DeleteDeliveries(ctx, DeliveryCollection, userId, addresses); err != nil {
+		return err
+	}
+	if err := database.AnonymizeInvoices(ctx, InvoiceCollection, userId); err != nil {
+		return err
+	}
+	if err := database.AnonymizeOrders(ctx, OrderCollection, userId); err != nil {
+		return err
+	}
+	return database.AnonymizeUser(ctx, UserCollection, userId)
+}
+
+// StartDeletionJob erases every account whose grace period has ended, checking once
+// every interval
+func StartDeletionJob(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for ; ; <-ticker.C {
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			userIds, err := database.DeletionsDue(ctx, UserCollection, time.Now())
+			if err != nil {
+				log.Println("Error finding accounts due for deletion: ", err)
+			}
+			for _, userId := range userIds {
+				if err := eraseAccount(ctx, userId); err != nil {
+					log.Printf("Error erasing account %s: %v", userId, err)
+					continue
+				}
+				entry := models.AuditEntry{TargetUserId: userId, Action: "erased account after grace period"}
+				if err := database.RecordAudit(ctx, AuditCollection, entry); err != nil {
+					log.Println("Error auditing account erasure: ", err)
+				}
+			}
+			cancel()
+		}
+	}()
+}
@@ -34,7 +34,11 @@ func AddProductAdmin() gin.HandlerFunc {
 			return
 		}
 		products.ProductId = primitive.NewObjectID()
-		if _, err := ProductCollection.InsertOne(ctx, products); err != nil {
+		err := database.WithEvents(ctx, OutboxCollection, func(ctx context.Context) error {
+			_, err := ProductCollection.InsertOne(ctx, products)
+			return err
+		}, models.NewEvent(models.EventProductChanged, products.ProductId.Hex(), ""))
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "not inserted"})
 			return
 		}
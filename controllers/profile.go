@@ -0,0 +1,320 @@
+package controllers
+
+import (
+	"context"
+	"go-ecommerce/database"
+	"go-ecommerce/dto"
+	"go-ecommerce/mailer"
+	"go-ecommerce/models"
+	"go-ecommerce/notify"
+	"go-ecommerce/passwords"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const emailChangeTTL = 24 * time.Hour
+
+// errNoPassword is returned to accounts created through an identity provider when an
+// action needs the current password; they can set one through the reset flow
+const errNoPassword = "this account has no password yet, set one through the password reset flow first"
+
+// GetProfile returns the profile of the authenticated user
+func GetProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		foundUser, err := database.FindUserById(ctx, UserCollection, userId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, dto.NewUserResponse(*foundUser))
+	}
+}
+
+// UpdateProfile changes the names, phone number and email language of the authenticated
+// user. Fields left out of the body are kept.
+func UpdateProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			FirstName *string `json:"firstName" validate:"omitempty,min=2,max=30"`
+			LastName  *string `json:"lastName" validate:"omitempty,min=2,max=30"`
+			Phone     *string `json:"phone" validate:"omitempty,min=5,max=20"`
+			Locale    *string `json:"locale"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if body.FirstName == nil && body.LastName == nil && body.Phone == nil && body.Locale == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "nothing to update"})
+			return
+		}
+		if body.Locale != nil && !notify.IsSupportedLocale(*body.Locale) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "locale must be one of " + strings.Join(notify.Locales(), ", ")})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if body.Phone != nil {
+			count, err := UserCollection.CountDocuments(ctx, bson.M{"phone": *body.Phone, "userid": bson.M{"$ne": userId}})
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			if count > 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "This phone number is already in use"})
+				return
+			}
+		}
+
+		if err := database.UpdateProfile(ctx, UserCollection, userId, body.FirstName, body.LastName, body.Phone, body.Locale); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		foundUser, err := database.FindUserById(ctx, UserCollection, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, dto.NewUserResponse(*foundUser))
+	}
+}
+
+// ChangePassword replaces the password of the authenticated user after checking the
+// current one. Every other session is signed out.
+func ChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			CurrentPassword string `json:"currentPassword" validate:"required"`
+			NewPassword     string `json:"newPassword" validate:"required,nefield=CurrentPassword"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := passwords.DefaultPolicy.Check(body.NewPassword); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		foundUser, ok := checkCurrentPassword(ctx, c, userId, body.CurrentPassword)
+		if !ok {
+			return
+		}
+
+		passwordHash, err := HashPassword(body.NewPassword)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.UpdatePassword(ctx, UserCollection, foundUser.UserId, passwordHash); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.RevokeOtherSessions(ctx, SessionCollection, foundUser.UserId, c.GetString("sid")); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Successfully changed the password")
+	}
+}
+
+// ChangeEmail starts moving the account to a new address. The current address stays in
+// use until the link sent to the new one is opened.
+func ChangeEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
+		}
+
+		var body struct {
+			NewEmail        string `json:"newEmail" validate:"required,email"`
+			CurrentPassword string `json:"currentPassword" validate:"required"`
+		}
+		if err := c.BindJSON(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		foundUser, ok := checkCurrentPassword(ctx, c, userId, body.CurrentPassword)
+		if !ok {
+			return
+		}
+		if *foundUser.Email == body.NewEmail {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "this is already your email address"})
+			return
+		}
+
+		count, err := UserCollection.CountDocuments(ctx, bson.M{"email": body.NewEmail})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if count > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": database.ErrEmailInUse.Error()})
+			return
+		}
+
+		if err := database.SetPendingEmail(ctx, UserCollection, userId, body.NewEmail); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		changeToken, err := database.CreateActionToken(ctx, ActionTokenCollection, userId, models.PurposeEmailChange, emailChangeTTL)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		link := appURL("/users/me/email/confirm", url.Values{"token": {changeToken}})
+		confirm := mailer.Message{
+			To:      body.NewEmail,
+			UserId:  userId,
+			Subject: "Confirm your new email address",
+			Text:    "Open the link below to start using this address for your account. It expires in 24 hours.\n\n" + link,
+		}
+		if err := Mailer.Send(ctx, confirm); err != nil {
+			log.Println("Error sending email change confirmation: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not send confirmation email"})
+			return
+		}
+
+		// the old address hears about the change in case the request was not the owner's
+		notice := mailer.Message{
+			To:      *foundUser.Email,
+			UserId:  userId,
+			Subject: "Your email address is being changed",
+			Text:    "A change of the email address on your account to " + body.NewEmail + " was requested. If this was not you, reset your password now.",
+		}
+		if err := Mailer.Send(ctx, notice); err != nil {
+			log.Println("Error sending email change notice: ", err)
+		}
+		c.JSON(http.StatusAccepted, "Check your new email address to confirm the change")
+	}
+}
+
+// ConfirmEmailChange serves the page the link sent to the new address opens. The token
+// is checked but not used; the page posts it to CompleteEmailChange.
+func ConfirmEmailChange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page := confirmation{
+			Title:   "Change your email address",
+			Prompt:  "Confirm that this becomes the email address of your account.",
+			Action:  "/users/me/email/confirm",
+			Button:  "Change email address",
+			Invalid: "This link is invalid or has expired. Request the change again from your account.",
+			Token:   c.Query("token"),
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		status := http.StatusOK
+		if page.Token == "" || database.CheckActionToken(ctx, ActionTokenCollection, page.Token, models.PurposeEmailChange) != nil {
+			page.Token, status = "", http.StatusBadRequest
+		}
+		renderConfirmation(c, status, page)
+	}
+}
+
+// CompleteEmailChange completes an email change with the token sent to the new address,
+// sent as JSON or a form field
+func CompleteEmailChange() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Token string `json:"token" form:"token" validate:"required"`
+		}
+		if err := c.ShouldBind(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		consumed, err := database.ConsumeActionToken(ctx, ActionTokenCollection, body.Token, models.PurposeEmailChange)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if _, err := database.ConfirmPendingEmail(ctx, UserCollection, consumed.UserId); err != nil {
+			status := http.StatusInternalServerError
+			if err == database.ErrEmailInUse || err == database.ErrNoPendingEmail {
+				status = http.StatusConflict
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Successfully changed the email address")
+	}
+}
+
+// checkCurrentPassword loads the user and verifies password against their stored hash.
+// Wrong passwords count towards the login lockout so the endpoints cannot be used to
+// guess it. It writes the error response itself when the check fails.
+func checkCurrentPassword(ctx context.Context, c *gin.Context, userId, password string) (*models.User, bool) {
+	foundUser, err := database.FindUserById(ctx, UserCollection, userId)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if foundUser.Password == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": errNoPassword})
+		return nil, false
+	}
+	if loginLocked(ctx, c, *foundUser.Email) {
+		return nil, false
+	}
+	if valid, _ := VerifyPassword(password, *foundUser.Password); !valid {
+		loginFailed(ctx, c, *foundUser.Email, foundUser.UserId)
+		return nil, false
+	}
+	return foundUser, true
+}
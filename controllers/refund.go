@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"go-ecommerce/database"
+	"go-ecommerce/dto"
+	"go-ecommerce/models"
+	"go-ecommerce/payment"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var LedgerCollection *mongo.Collection = database.LedgerData(database.Client, "Ledger")
+
+// refund describes money to pay back for an order, optionally split across its lines
+type refund struct {
+	Amount   int
+	Lines    []models.RefundLine
+	Source   string
+	Reason   string
+	ReturnId *primitive.ObjectID
+	ActorId  string
+}
+
+// issueRefund pays r back and records it in the ledger. A pending entry is written
+// together with reserving the amount on the order, so it can never exceed what was
+// captured, before any money moves; settleRefund then pays it back.
+func (app *Application) issueRefund(ctx context.Context, order models.Order, r refund) (*models.LedgerEntry, error) {
+	entry := models.LedgerEntry{
+		EntryId:   primitive.NewObjectID(),
+		OrderId:   order.OrderId,
+		UserId:    order.UserId,
+		Kind:      models.LedgerRefund,
+		Status:    models.LedgerPending,
+		Amount:    r.Amount,
+		Currency:  ledgerCurrency(order),
+		Method:    models.RefundToStoreCredit,
+		Source:    r.Source,
+		Reason:    r.Reason,
+		Lines:     r.Lines,
+		ReturnId:  r.ReturnId,
+		CreatedBy: r.ActorId,
+		CreatedAt: time.Now(),
+	}
+	if order.Charge != nil {
+		entry.Method = models.RefundToProvider
+	}
+	if err := database.ReserveRefund(ctx, app.orderCollection, LedgerCollection, OutboxCollection, order, entry); err != nil {
+		return nil, err
+	}
+	return app.settleRefund(ctx, order, entry)
+}
+
+// settleRefund pays back a pending refund entry, through the payment provider for
+// digitally paid orders and as store credit for cash on delivery orders, and marks it
+// completed with a RefundIssued event. If the money could not be paid back the entry
+// fails and its reservation is released. The entry id is the provider's idempotency
+// key, so settling an entry again after an interruption never pays twice.
+func (app *Application) settleRefund(ctx context.Context, order models.Order, entry models.LedgerEntry) (*models.LedgerEntry, error) {
+	var err error
+	if entry.Method == models.RefundToProvider && PaymentProvider == nil {
+		err = payment.ErrNoProvider
+	} else if entry.Method == models.RefundToProvider {
+		var refundId string
+		if refundId, err = PaymentProvider.Refund(ctx, order.Charge.TransactionId, entry.Amount, entry.EntryId.Hex()); err == nil {
+			entry.TransactionId = refundId
+			// the money has moved; an entry left pending is settled by the next attempt
+			err := database.WithEvents(ctx, OutboxCollection, func(ctx context.Context) error {
+				return database.SettleRefund(ctx, LedgerCollection, entry.EntryId, models.LedgerCompleted, refundId)
+			}, refundIssued(order, entry))
+			if err != nil && err != database.ErrRefundSettled {
+				log.Printf("Error completing refund %s of order %s: %v", entry.EntryId.Hex(), order.OrderId.Hex(), err)
+				return nil, database.ErrCantRecordRefund
+			}
+			if err := database.MarkChargeRefunded(ctx, app.orderCollection, order.OrderId); err != nil {
+				log.Println("Error updating refunded charge: ", err)
+			}
+		}
+	} else {
+		err = database.WithEvents(ctx, OutboxCollection, func(ctx context.Context) error {
+			if err := database.AddStoreCredit(ctx, app.userCollection, order.UserId, entry.Amount); err != nil {
+				return err
+			}
+			return database.SettleRefund(ctx, LedgerCollection, entry.EntryId, models.LedgerCompleted, "")
+		}, refundIssued(order, entry))
+		if err == database.ErrRefundSettled {
+			err = nil
+		}
+	}
+	if err != nil {
+		failErr := database.WithEvents(ctx, OutboxCollection, func(ctx context.Context) error {
+			if err := database.SettleRefund(ctx, LedgerCollection, entry.EntryId, models.LedgerFailed, ""); err != nil {
+				return err
+			}
+			return database.ReleaseRefund(ctx, app.orderCollection, order.OrderId, entry.Amount, entry.Lines)
+		})
+		if failErr != nil {
+			log.Printf("Error releasing failed refund %s of order %s: %v", entry.EntryId.Hex(), order.OrderId.Hex(), failErr)
+		}
+		return nil, err
+	}
+
+	entry.Status = models.LedgerCompleted
+	return &entry, nil
+}
+
+// refundIssued is the event recorded when the refund entry is completed
+func refundIssued(order models.Order, entry models.LedgerEntry) models.Event {
+	return models.NewEvent(models.EventRefundIssued, entry.EntryId.Hex(), order.UserId)
+}
+
+// recordPayment writes a charge or void of the order's payment to the ledger
+func recordPayment(ctx context.Context, order models.Order, kind, actorId string) {
+	entry := models.LedgerEntry{
+		EntryId:       primitive.NewObjectID(),
+		OrderId:       order.OrderId,
+		UserId:        order.UserId,
+		Kind:          kind,
+		Status:        models.LedgerCompleted,
+		Amount:        order.Charge.Amount,
+		Currency:      order.Charge.Currency,
+		TransactionId: order.Charge.TransactionId,
+		CreatedBy:     actorId,
+		CreatedAt:     time.Now(),
+	}
+	if err := database.RecordLedgerEntry(ctx, LedgerCollection, entry); err != nil {
+		log.Printf("Error recording %s of order %s in the ledger: %v", kind, order.OrderId.Hex(), err)
+	}
+}
+
+func ledgerCurrency(order models.Order) string {
+	if order.Charge != nil {
+		return order.Charge.Currency
+	}
+	return payment.Currency()
+}
+
+// RefundOrder pays back part or all of an order, for example as a goodwill gesture
+func (app *Application) RefundOrder() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.RefundRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		order, err := database.FindOrder(ctx, app.orderCollection, c.Param("orderId"), "")
+		if err != nil {
+			c.JSON(orderStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		r := refund{Amount: req.Amount, Source: models.RefundForGoodwill, Reason: req.Reason, ActorId: c.GetString("uid")}
+		if len(req.Lines) > 0 {
+			sum := 0
+			for _, line := range req.Lines {
+				lineId, err := primitive.ObjectIDFromHex(line.LineId)
+				if err != nil {
+					c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "line " + line.LineId + " is not in the order"})
+					return
+				}
+				r.Lines = append(r.Lines, models.RefundLine{LineId: lineId, Amount: line.Amount})
+				sum += line.Amount
+			}
+			if r.Amount != 0 && r.Amount != sum {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("the amount %d does not match the lines, which add up to %d", r.Amount, sum)})
+				return
+			}
+			r.Amount = sum
+		}
+		if r.Amount == 0 {
+			r.Amount = order.CapturedAmount() - order.RefundedAmount
+		}
+
+		entry := models.AuditEntry{
+			ActorId:      r.ActorId,
+			TargetUserId: order.UserId,
+			Action:       "refunded " + strconv.Itoa(r.Amount) + " of order " + order.OrderId.Hex() + ": " + r.Reason,
+			IP:           c.ClientIP(),
+		}
+		if err := database.RecordAudit(ctx, AuditCollection, entry); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		refunded, err := app.issueRefund(ctx, *order, r)
+		if err != nil {
+			c.JSON(refundStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, dto.NewLedgerEntryResponse(*refunded))
+	}
+}
+
+// refundStatus maps refund errors to response codes. Anything but our own checks
+// failing means the provider refused or could not be reached.
+func refundStatus(err error) int {
+	switch err {
+	case database.ErrRefundExceedsPayment:
+		return http.StatusConflict
+	case database.ErrCantRecordRefund, database.ErrUserNotFound:
+		return http.StatusInternalServerError
+	}
+	return http.StatusBadGateway
+}
+
+// ListLedger returns the payments and refunds of one of the user's orders
+func (app *Application) ListLedger() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := targetUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		order, err := database.FindOrder(ctx, app.orderCollection, c.Param("orderId"), userId)
+		if err != nil {
+			c.JSON(orderStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		entries, err := database.ListLedger(ctx, LedgerCollection, order.OrderId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, dto.NewLedgerEntryResponses(entries))
+	}
+}
@@ -0,0 +1,335 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"go-ecommerce/database"
+	"go-ecommerce/dto"
+	"go-ecommerce/models"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const defaultReturnWindow = 30 * 24 * time.Hour
+
+var ReturnCollection *mongo.Collection = database.ReturnData(database.Client, "Returns")
+
+// ReturnWindow reads RETURN_WINDOW, e.g. "336h", how long after delivery items can be
+// returned. Orders not delivered in full count from when they were placed.
+func ReturnWindow() time.Duration {
+	value := strings.TrimSpace(os.Getenv("RETURN_WINDOW"))
+	if value == "" {
+		return defaultReturnWindow
+	}
+	window, err := time.ParseDuration(value)
+	if err != nil || window < 0 {
+		log.Printf("invalid RETURN_WINDOW %q, using the default", value)
+		return defaultReturnWindow
+	}
+	return window
+}
+
+// returnStatus maps return errors to response codes
+func returnStatus(err error) int {
+	switch err {
+	case database.ErrReturnNotFound:
+		return http.StatusNotFound
+	case database.ErrReturnIdIsNotValid:
+		return http.StatusBadRequest
+	case database.ErrReturnStatusChanged, database.ErrReturnExceedsOrder:
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// RequestReturn asks to send back shipped items of one of the user's orders within the
+// return window, giving a reason for every line
+func (app *Application) RequestReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := targetUserId(c)
+		if !ok {
+			return
+		}
+
+		var req dto.ReturnRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		order, err := database.FindOrder(ctx, app.orderCollection, c.Param("orderId"), userId)
+		if err != nil {
+			c.JSON(orderStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if order.Status != models.OrderPartiallyShipped && order.Status != models.OrderShipped && order.Status != models.OrderDelivered {
+			c.JSON(http.StatusConflict, gin.H{"error": database.ErrOrderNotReturnable.Error()})
+			return
+		}
+		windowStart := order.OrderedAt
+		if order.DeliveredAt != nil {
+			windowStart = *order.DeliveredAt
+		}
+		if time.Since(windowStart) > ReturnWindow() {
+			c.JSON(http.StatusConflict, gin.H{"error": database.ErrReturnWindowClosed.Error()})
+			return
+		}
+
+		returnable := make(map[primitive.ObjectID]int)
+		for _, line := range order.LineItems {
+			returnable[line.LineId] = line.ShippedQuantity - line.ReturnedQuantity
+		}
+
+		now := time.Now()
+		ret := models.Return{
+			ReturnId:  primitive.NewObjectID(),
+			OrderId:   order.OrderId,
+			UserId:    order.UserId,
+			Items:     make([]models.ReturnItem, 0, len(req.Items)),
+			Note:      req.Note,
+			Status:    models.ReturnRequested,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		for _, item := range req.Items {
+			lineId, err := primitive.ObjectIDFromHex(item.LineId)
+			left, inOrder := returnable[lineId]
+			if err != nil || !inOrder {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "line " + item.LineId + " is not in the order, or named twice"})
+				return
+			}
+			if item.Quantity > left {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("only %d of line %s can be returned", left, item.LineId)})
+				return
+			}
+			delete(returnable, lineId)
+			ret.Items = append(ret.Items, models.ReturnItem{LineId: lineId, Quantity: item.Quantity, Reason: item.Reason})
+		}
+
+		if err := database.CreateReturn(ctx, app.orderCollection, ReturnCollection, *order, ret); err != nil {
+			c.JSON(returnStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, dto.NewReturnResponse(ret))
+	}
+}
+
+// ListOrderReturns returns the returns of one of the user's orders
+func (app *Application) ListOrderReturns() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := targetUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		order, err := database.FindOrder(ctx, app.orderCollection, c.Param("orderId"), userId)
+		if err != nil {
+			c.JSON(orderStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		returns, err := database.ListReturns(ctx, ReturnCollection, bson.M{"orderId": order.OrderId})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, dto.NewReturnResponses(returns))
+	}
+}
+
+// ListReturns returns all returns, or those in ?status, for staff to work through
+func ListReturns() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		filter := bson.M{}
+		if status := c.Query("status"); status != "" {
+			filter["status"] = status
+		}
+		returns, err := database.ListReturns(ctx, ReturnCollection, filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, dto.NewReturnResponses(returns))
+	}
+}
+
+// ReviewReturn approves or rejects a requested return. The items of a rejected return
+// can be returned again.
+func (app *Application) ReviewReturn(approve bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.ReviewReturnRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.BindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		status := models.ReturnRejected
+		if approve {
+			status = models.ReturnApproved
+		}
+		set := bson.M{"status": status, "reviewedBy": c.GetString("uid"), "reviewNote": req.Note, "updatedAt": time.Now()}
+		ret, err := database.TransitionReturn(ctx, ReturnCollection, c.Param("returnId"), models.ReturnRequested, set)
+		if err != nil {
+			c.JSON(returnStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		if !approve {
+			if err := database.ReleaseReturnedItems(ctx, app.orderCollection, ret.OrderId, ret.Items); err != nil {
+				log.Printf("Error releasing items of rejected return %s: %v", ret.ReturnId.Hex(), err)
+			}
+		}
+		auditReturn(ctx, c, *ret, status+" return "+ret.ReturnId.Hex())
+		c.JSON(http.StatusOK, dto.NewReturnResponse(*ret))
+	}
+}
+
+// ReceiveReturn books the goods of an approved return as received, puts them back into
+// stock if asked to and refunds them. If the refund fails the return stays received and
+// calling this again retries it.
+func (app *Application) ReceiveReturn() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.ReceiveReturnRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.BindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		returnId := c.Param("returnId")
+		ret, err := database.FindReturn(ctx, ReturnCollection, returnId)
+		if err != nil {
+			c.JSON(returnStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		order, err := database.FindOrder(ctx, app.orderCollection, ret.OrderId.Hex(), "")
+		if err != nil {
+			c.JSON(orderStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+
+		if ret.Status == models.ReturnApproved {
+			now := time.Now()
+			set := bson.M{"status": models.ReturnReceived, "restocked": req.Restock, "receivedAt": now, "updatedAt": now}
+			if ret, err = database.TransitionReturn(ctx, ReturnCollection, returnId, models.ReturnApproved, set); err != nil {
+				c.JSON(returnStatus(err), gin.H{"error": err.Error()})
+				return
+			}
+			if ret.Restocked {
+				if err := database.ReleaseStock(ctx, app.prodCollection, OutboxCollection, returnedLines(*order, *ret)); err != nil {
+					log.Printf("Error restocking return %s: %v", ret.ReturnId.Hex(), err)
+				}
+			}
+			auditReturn(ctx, c, *ret, "received return "+ret.ReturnId.Hex())
+		}
+		if ret.Status != models.ReturnReceived {
+			c.JSON(http.StatusConflict, gin.H{"error": database.ErrReturnStatusChanged.Error()})
+			return
+		}
+
+		refunded, err := app.refundReturn(ctx, c, *order, *ret)
+		if err != nil {
+			c.JSON(refundStatus(err), gin.H{"error": "the goods were received but the refund failed: " + err.Error()})
+			return
+		}
+
+		now := time.Now()
+		set := bson.M{"status": models.ReturnCompleted, "completedAt": now, "updatedAt": now}
+		if refunded != nil {
+			set["refundAmount"] = refunded.Amount
+			set["refundId"] = refunded.EntryId.Hex()
+		}
+		if ret, err = database.TransitionReturn(ctx, ReturnCollection, returnId, models.ReturnReceived, set); err != nil {
+			c.JSON(returnStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, dto.NewReturnResponse(*ret))
+	}
+}
+
+// refundReturn pays back the returned lines, each for what it cost, and returns the
+// ledger entry of the refund, or nil when there was nothing to pay back. A return is
+// only ever refunded once: when retried after the refund went through, the entry of
+// that refund is returned, and a refund that was interrupted before it was settled is
+// settled now.
+func (app *Application) refundReturn(ctx context.Context, c *gin.Context, order models.Order, ret models.Return) (*models.LedgerEntry, error) {
+	existing, err := database.FindReturnRefund(ctx, LedgerCollection, ret.ReturnId)
+	if err != nil {
+		return nil, err
+	}
+	if existing != nil {
+		if existing.Status == models.LedgerPending {
+			return app.settleRefund(ctx, order, *existing)
+		}
+		return existing, nil
+	}
+
+	r := refund{Source: models.RefundForReturn, Reason: "return " + ret.ReturnId.Hex(), ReturnId: &ret.ReturnId, ActorId: c.GetString("uid")}
+	for _, line := range returnedLines(order, ret) {
+		r.Lines = append(r.Lines, models.RefundLine{LineId: line.LineId, Amount: line.LineTotal()})
+		r.Amount += line.LineTotal()
+	}
+	if r.Amount == 0 {
+		return nil, nil
+	}
+	return app.issueRefund(ctx, order, r)
+}
+
+// returnedLines lists the order lines of a return with the returned quantities
+func returnedLines(order models.Order, ret models.Return) []models.LineItem {
+	lines := make([]models.LineItem, 0, len(ret.Items))
+	for _, item := range ret.Items {
+		for _, line := range order.LineItems {
+			if line.LineId == item.LineId {
+				line.Quantity = item.Quantity
+				lines = append(lines, line)
+			}
+		}
+	}
+	return lines
+}
+
+func auditReturn(ctx context.Context, c *gin.Context, ret models.Return, action string) {
+	entry := models.AuditEntry{
+		ActorId:      c.GetString("uid"),
+		TargetUserId: ret.UserId,
+		Action:       action,
+		IP:           c.ClientIP(),
+	}
+	if err := database.RecordAudit(ctx, AuditCollection, entry); err != nil {
+		log.Println("Error auditing return: ", err)
+	}
+}
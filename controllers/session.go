@@ -0,0 +1,182 @@
+package controllers
+
+import (
+	"context"
+	"go-ecommerce/database"
+	"go-ecommerce/dto"
+	"go-ecommerce/middleware"
+	"go-ecommerce/models"
+	generate "go-ecommerce/token"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var SessionCollection *mongo.Collection = database.SessionData(database.Client, "Sessions")
+
+// startSession records a new session for the device making the request and issues the
+// token pair bound to it
+func startSession(ctx context.Context, c *gin.Context, foundUser models.User) (string, string, error) {
+	sessionId := primitive.NewObjectID()
+	token, refreshToken, err := generate.TokenGenerator(*foundUser.Email, *foundUser.FirstName, *foundUser.LastName, foundUser.UserId, foundUser.Role, sessionId.Hex())
+	if err != nil {
+		return "", "", err
+	}
+
+	_, err = database.CreateSession(ctx, SessionCollection, sessionId, foundUser.UserId, database.HashActionToken(refreshToken), c.Request.UserAgent(), c.ClientIP(), generate.RefreshTokenLifetime)
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
+}
+
+// RefreshToken exchanges a refresh token for a new token pair. Refresh tokens rotate:
+// each one works once, and presenting a used one again revokes the whole session.
+func RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			RefreshToken string `json:"refreshToken"`
+		}
+		if c.Request.ContentLength > 0 {
+			if err := c.BindJSON(&body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		fromCookie := false
+		if body.RefreshToken == "" {
+			cookie, err := c.Cookie(middleware.RefreshCookie)
+			if err != nil || cookie == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "refreshToken is required"})
+				return
+			}
+			if !middleware.ValidCSRF(c) {
+				c.JSON(http.StatusForbidden, gin.H{"error": "Missing or invalid CSRF token"})
+				return
+			}
+			body.RefreshToken, fromCookie = cookie, true
+		}
+
+		claims, msg := generate.ValidateToken(body.RefreshToken, generate.RefreshToken)
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		session, err := database.ActiveSession(ctx, SessionCollection, claims.SessionId, claims.Uid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has expired or been revoked"})
+			return
+		}
+		foundUser, err := database.FindUserById(ctx, UserCollection, claims.Uid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+
+		token, refreshToken, err := generate.TokenGenerator(*foundUser.Email, *foundUser.FirstName, *foundUser.LastName, foundUser.UserId, foundUser.Role, session.ID.Hex())
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = database.RotateRefreshToken(ctx, SessionCollection, session.ID, database.HashActionToken(body.RefreshToken), database.HashActionToken(refreshToken), c.Request.UserAgent(), c.ClientIP(), generate.RefreshTokenLifetime)
+		if err == database.ErrRefreshTokenReused {
+			// a replayed refresh token means it leaked, so nobody gets to keep the session
+			if err := database.RevokeSession(ctx, SessionCollection, session.ID.Hex(), foundUser.UserId); err != nil && err != database.ErrSessionNotFound {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if fromCookie {
+			csrfToken, err := middleware.SetSessionCookies(c, token, refreshToken)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.Header(middleware.CSRFHeader, csrfToken)
+		}
+		c.JSON(http.StatusOK, gin.H{"token": token, "refreshToken": refreshToken})
+	}
+}
+
+// ListSessions shows the devices the user is signed in on
+func ListSessions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		sessions, err := database.ListSessions(ctx, SessionCollection, userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong, please try again later"})
+			return
+		}
+
+		c.JSON(http.StatusOK, dto.NewSessionResponses(sessions, c.GetString("sid")))
+	}
+}
+
+// RevokeSession signs the user out of one of their sessions
+func RevokeSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := database.RevokeSession(ctx, SessionCollection, c.Param("id"), userId); err != nil {
+			status := http.StatusInternalServerError
+			if err == database.ErrSessionNotFound || err == database.ErrSessionIdIsNotValid {
+				status = http.StatusNotFound
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		if c.Param("id") == c.GetString("sid") {
+			middleware.ClearSessionCookies(c)
+		}
+		c.JSON(http.StatusOK, "Successfully revoked the session")
+	}
+}
+
+// Logout ends the current session and clears the session cookies of browser clients
+func Logout() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := database.RevokeSession(ctx, SessionCollection, c.GetString("sid"), userId); err != nil && err != database.ErrSessionNotFound {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		middleware.ClearSessionCookies(c)
+		c.JSON(http.StatusOK, "Successfully logged out")
+	}
+}
@@ -0,0 +1,240 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"go-ecommerce/database"
+	"go-ecommerce/dto"
+	"go-ecommerce/models"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ShipmentCollection *mongo.Collection = database.ShipmentData(database.Client, "Shipments")
+
+// CreateShipment records a parcel sent to one destination of an order, with its carrier
+// and tracking number. The order becomes shipped once all of its items have shipped.
+func (app *Application) CreateShipment() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.ShipmentRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		order, err := database.FindOrder(ctx, app.orderCollection, c.Param("orderId"), "")
+		if err != nil {
+			c.JSON(orderStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		if order.Status != models.OrderPlaced && order.Status != models.OrderPartiallyShipped {
+			c.JSON(http.StatusConflict, gin.H{"error": database.ErrOrderNotShippable.Error()})
+			return
+		}
+
+		shipment, ok := planShipment(c, *order, req)
+		if !ok {
+			return
+		}
+
+		entry := models.AuditEntry{
+			ActorId:      c.GetString("uid"),
+			TargetUserId: order.UserId,
+			Action:       "shipped order " + order.OrderId.Hex() + " with " + shipment.Carrier + " " + shipment.TrackingNumber,
+			IP:           c.ClientIP(),
+		}
+		if err := database.RecordAudit(ctx, AuditCollection, entry); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := database.CreateShipment(ctx, app.orderCollection, ShipmentCollection, OutboxCollection, *order, shipment); err != nil {
+			status := http.StatusInternalServerError
+			if err == database.ErrShipmentExceedsOrder {
+				status = http.StatusConflict
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusCreated, dto.NewShipmentResponse(shipment))
+	}
+}
+
+// planShipment builds the shipment described by req, checking that its items belong to
+// the destination and are still left to ship. Problems are answered with 422.
+func planShipment(c *gin.Context, order models.Order, req dto.ShipmentRequest) (models.Shipment, bool) {
+	var destination *models.Destination
+	for i := range order.Destinations {
+		if order.Destinations[i].DestinationId.Hex() == req.DestinationId {
+			destination = &order.Destinations[i]
+		}
+	}
+	if destination == nil {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "the order has no such destination"})
+		return models.Shipment{}, false
+	}
+
+	left := make(map[primitive.ObjectID]int)
+	for _, line := range order.LineItems {
+		if line.DestinationId == destination.DestinationId {
+			left[line.LineId] = line.Quantity - line.ShippedQuantity
+		}
+	}
+
+	requested := make(map[primitive.ObjectID]int)
+	lineIds := make([]primitive.ObjectID, 0)
+	if len(req.Items) == 0 {
+		for _, line := range order.LineItems {
+			if left[line.LineId] > 0 {
+				requested[line.LineId] = left[line.LineId]
+				lineIds = append(lineIds, line.LineId)
+			}
+		}
+	}
+	for _, item := range req.Items {
+		lineId, err := primitive.ObjectIDFromHex(item.LineId)
+		if _, ok := left[lineId]; err != nil || !ok {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "line " + item.LineId + " does not ship to this destination"})
+			return models.Shipment{}, false
+		}
+		if _, ok := requested[lineId]; !ok {
+			lineIds = append(lineIds, lineId)
+		}
+		requested[lineId] += item.Quantity
+	}
+	if len(lineIds) == 0 {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "nothing is left to ship to this destination"})
+		return models.Shipment{}, false
+	}
+
+	now := time.Now()
+	shipment := models.Shipment{
+		ShipmentId:     primitive.NewObjectID(),
+		OrderId:        order.OrderId,
+		UserId:         order.UserId,
+		DestinationId:  destination.DestinationId,
+		Carrier:        req.Carrier,
+		TrackingNumber: req.TrackingNumber,
+		TrackingURL:    req.TrackingURL,
+		Items:          make([]models.ShipmentItem, 0, len(lineIds)),
+		Status:         models.ShipmentShipped,
+		Events:         []models.ShipmentEvent{{Status: models.ShipmentShipped, OccurredAt: now}},
+		CreatedAt:      now,
+		UpdatedAt:      now,
+	}
+	if shipment.Carrier == "" {
+		shipment.Carrier = destination.Quote.Carrier
+	}
+	for _, lineId := range lineIds {
+		if requested[lineId] > left[lineId] {
+			c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("only %d of line %s are left to ship", left[lineId], lineId.Hex())})
+			return models.Shipment{}, false
+		}
+		shipment.Items = append(shipment.Items, models.ShipmentItem{LineId: lineId, Quantity: requested[lineId]})
+	}
+	return shipment, true
+}
+
+// AddShipmentEvent records a tracking update. When the last shipment of a fully
+// shipped order is delivered, the order becomes delivered.
+func (app *Application) AddShipmentEvent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req dto.ShipmentEventRequest
+		if err := c.BindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		shipment, err := database.AddShipmentEvent(ctx, ShipmentCollection, c.Param("shipmentId"), dto.NewShipmentEvent(req))
+		if err != nil {
+			status := http.StatusInternalServerError
+			if err == database.ErrShipmentNotFound {
+				status = http.StatusNotFound
+			} else if err == database.ErrShipmentIdIsNotValid {
+				status = http.StatusBadRequest
+			}
+			c.JSON(status, gin.H{"error": err.Error()})
+			return
+		}
+
+		if shipment.Status == models.ShipmentDelivered {
+			app.orderDelivered(ctx, shipment.OrderId)
+		}
+		c.JSON(http.StatusOK, dto.NewShipmentResponse(*shipment))
+	}
+}
+
+// ListShipments returns the shipments of one of the user's orders with their tracking
+func (app *Application) ListShipments() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := targetUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		order, err := database.FindOrder(ctx, app.orderCollection, c.Param("orderId"), userId)
+		if err != nil {
+			c.JSON(orderStatus(err), gin.H{"error": err.Error()})
+			return
+		}
+		shipments, err := database.ListShipments(ctx, ShipmentCollection, order.OrderId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, dto.NewShipmentResponses(shipments))
+	}
+}
+
+// orderDelivered marks the order delivered if this was its last shipment. Cash on
+// delivery orders are paid at that point and get their invoice.
+func (app *Application) orderDelivered(ctx context.Context, orderId primitive.ObjectID) {
+	order, err := database.MarkOrderDelivered(ctx, app.orderCollection, ShipmentCollection, orderId)
+	if err != nil || order == nil {
+		if err != nil {
+			log.Println("Error updating delivered order: ", err)
+		}
+		return
+	}
+	if !order.PaymentMethod.COD || order.PaidAt != nil {
+		return
+	}
+
+	paidAt := *order.DeliveredAt
+	err = database.WithEvents(ctx, OutboxCollection, func(ctx context.Context) error {
+		return database.MarkOrderPaid(ctx, app.orderCollection, order.OrderId, paidAt)
+	}, models.NewEvent(models.EventOrderPaid, order.OrderId.Hex(), order.UserId))
+	if err != nil {
+		// ErrOrderAlreadyPaid: a concurrent delivery update got here first
+		if err != database.ErrOrderAlreadyPaid {
+			log.Println("Error marking cash on delivery order paid: ", err)
+		}
+		return
+	}
+	order.PaidAt = &paidAt
+	if _, err := app.issueInvoice(ctx, *order); err != nil {
+		log.Printf("Error issuing the invoice of order %s: %v", order.OrderId.Hex(), err)
+	}
+}
@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"context"
+	"go-ecommerce/database"
+	"go-ecommerce/models"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// errInvalidCredentials is the only failure Login reports, so a missing account and a
+// wrong password cannot be told apart
+const errInvalidCredentials = "Login or Password is incorrect"
+
+var (
+	LoginAttemptCollection *mongo.Collection = database.LoginAttemptData(database.Client, "LoginAttempts")
+
+	accountThrottle = database.LoginThrottle{Threshold: 5, BaseLockout: 30 * time.Second, MaxLockout: time.Hour, Window: time.Hour}
+	ipThrottle      = database.LoginThrottle{Threshold: 20, BaseLockout: time.Minute, MaxLockout: time.Hour, Window: time.Hour}
+
+	dummyHashOnce     sync.Once
+	dummyPasswordHash string
+)
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// burnPasswordCheck verifies a password against a throwaway hash so that a login for a
+// missing account takes as long as one with a wrong password
+func burnPasswordCheck(password string) {
+	dummyHashOnce.Do(func() {
+		var err error
+		if dummyPasswordHash, err = HashPassword("not-a-real-password"); err != nil {
+			log.Println("Error creating dummy password hash: ", err)
+		}
+	})
+	VerifyPassword(password, dummyPasswordHash)
+}
+
+// loginLocked aborts with 429 when the account or the client IP is locked out
+func loginLocked(ctx context.Context, c *gin.Context, email string) bool {
+	lockedUntil, err := database.LoginLockedUntil(ctx, LoginAttemptCollection, accountKey(email), ipKey(c.ClientIP()))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Something went wrong, please try again later"})
+		return true
+	}
+	if wait := time.Until(lockedUntil); wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "Too many failed login attempts, try again later"})
+		return true
+	}
+	return false
+}
+
+// loginFailed counts a failed attempt against the account and the client IP, writes any
+// resulting lockout to the audit log and responds with the generic credentials error.
+// userId is empty when no account exists for email.
+func loginFailed(ctx context.Context, c *gin.Context, email, userId string) {
+	ip := c.ClientIP()
+	for _, attempt := range []struct {
+		key      string
+		throttle database.LoginThrottle
+	}{
+		{accountKey(email), accountThrottle},
+		{ipKey(ip), ipThrottle},
+	} {
+		lockedUntil, err := database.RecordLoginFailure(ctx, LoginAttemptCollection, attempt.key, attempt.throttle)
+		if err != nil || lockedUntil == nil {
+			continue
+		}
+
+		entry := models.AuditEntry{
+			TargetUserId: userId,
+			Action:       "login locked " + attempt.key + " until " + lockedUntil.Format(time.RFC3339),
+			IP:           ip,
+		}
+		if err := database.RecordAudit(ctx, AuditCollection, entry); err != nil {
+			log.Println("Error auditing login lockout: ", err)
+		}
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": errInvalidCredentials})
+}
+
+// loginSucceeded resets the failure counter of the account
+func loginSucceeded(ctx context.Context, email string) {
+	if err := database.ClearLoginFailures(ctx, LoginAttemptCollection, accountKey(email)); err != nil {
+		log.Println("Error clearing login failures: ", err)
+	}
+}
@@ -0,0 +1,140 @@
+package controllers
+
+import (
+	"context"
+	"go-ecommerce/database"
+	"go-ecommerce/mailer"
+	"go-ecommerce/models"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	// a user may request a new verification email once a minute and five times a day
+	resendInterval = time.Minute
+	resendDailyCap = 5
+)
+
+// sendVerificationEmail issues a verification token and mails the link to address
+func sendVerificationEmail(ctx context.Context, userId, address string) error {
+	verificationToken, err := database.CreateActionToken(ctx, ActionTokenCollection, userId, models.PurposeEmailVerification, emailVerificationTTL)
+	if err != nil {
+		return err
+	}
+
+	link := appURL("/users/verify", url.Values{"token": {verificationToken}})
+	msg := mailer.Message{
+		To:      address,
+		UserId:  userId,
+		Subject: "Verify your email address",
+		Text:    "Please confirm your email address by opening the link below. It expires in 24 hours.\n\n" + link,
+	}
+	return Mailer.Send(ctx, msg)
+}
+
+// ConfirmVerification serves the page a verification link opens. The token is checked
+// but not used; the page posts it to VerifyEmail.
+func ConfirmVerification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page := confirmation{
+			Title:   "Verify your email address",
+			Prompt:  "Confirm that this email address belongs to you.",
+			Action:  "/users/verify",
+			Button:  "Verify email address",
+			Invalid: "This verification link is invalid or has expired. Request a new one from your account.",
+			Token:   c.Query("token"),
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		status := http.StatusOK
+		if page.Token == "" || database.CheckActionToken(ctx, ActionTokenCollection, page.Token, models.PurposeEmailVerification) != nil {
+			page.Token, status = "", http.StatusBadRequest
+		}
+		renderConfirmation(c, status, page)
+	}
+}
+
+// VerifyEmail marks the address verified with the token from a verification link, sent
+// as JSON or a form field
+func VerifyEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var body struct {
+			Token string `json:"token" form:"token" validate:"required"`
+		}
+		if err := c.ShouldBind(&body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := Validate.Struct(body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "token is required"})
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		consumed, err := database.ConsumeActionToken(ctx, ActionTokenCollection, body.Token, models.PurposeEmailVerification)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := database.MarkEmailVerified(ctx, UserCollection, consumed.UserId); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, "Successfully verified the email address")
+	}
+}
+
+func ResendVerification() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userId, ok := currentUserId(c)
+		if !ok {
+			return
+		}
+
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		foundUser, err := database.FindUserById(ctx, UserCollection, userId)
+		if err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if foundUser.EmailVerified {
+			c.JSON(http.StatusConflict, gin.H{"error": "email address is already verified"})
+			return
+		}
+
+		recent, err := database.CountRecentActionTokens(ctx, ActionTokenCollection, userId, models.PurposeEmailVerification, time.Now().Add(-resendInterval))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		today, err := database.CountRecentActionTokens(ctx, ActionTokenCollection, userId, models.PurposeEmailVerification, time.Now().Add(-24*time.Hour))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if recent > 0 || today >= resendDailyCap {
+			c.Header("Retry-After", strconv.Itoa(int(resendInterval.Seconds())))
+			c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many verification emails requested, try again later"})
+			return
+		}
+
+		if err := sendVerificationEmail(ctx, userId, *foundUser.Email); err != nil {
+			log.Println("Error sending verification email: ", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "could not send verification email"})
+			return
+		}
+		c.JSON(http.StatusAccepted, "Verification email sent")
+	}
+}
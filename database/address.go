@@ -0,0 +1,180 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrAddressNotFound       = errors.New("address not found")
+	ErrAddressIdIsNotValid   = errors.New("address id is not valid")
+	ErrCantUpdateAddressBook = errors.New("cant update address book")
+)
+
+// Address book updates are aggregation pipelines over the whole addressDetails array so
+// that the default flags move from one address to another in a single atomic write.
+
+// clearedDefaults returns an expression for the current address book in which the
+// default flags are cleared wherever address claims them
+func clearedDefaults(address models.Address) bson.M {
+	return bson.M{"$map": bson.M{
+		"input": bson.M{"$ifNull": bson.A{"$addressDetails", bson.A{}}},
+		"as":    "a",
+		"in": bson.M{"$mergeObjects": bson.A{"$$a", bson.M{
+			"defaultShipping": bson.M{"$and": bson.A{!address.DefaultShipping, "$$a.defaultShipping"}},
+			"defaultBilling":  bson.M{"$and": bson.A{!address.DefaultBilling, "$$a.defaultBilling"}},
+		}}},
+	}}
+}
+
+func updateAddressBook(ctx context.Context, userCollection *mongo.Collection, filter bson.M, addressBook interface{}) error {
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "addressDetails", Value: addressBook},
+		{Key: "updatedat", Value: time.Now()},
+	}}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateAddressBook
+	}
+	if result.MatchedCount == 0 {
+		return ErrAddressNotFound
+	}
+	return nil
+}
+
+// ListAddresses returns the address book of the user
+func ListAddresses(ctx context.Context, userCollection *mongo.Collection, userId string) ([]models.Address, error) {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrUserIdIsNotValid
+	}
+
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"addressDetails": 1})
+	if err = userCollection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		log.Println(err)
+		return nil, err
+	}
+	if user.AddressDetails == nil {
+		return make([]models.Address, 0), nil
+	}
+	return user.AddressDetails, nil
+}
+
+// FindAddress returns one address of the user
+func FindAddress(ctx context.Context, userCollection *mongo.Collection, userId string, addressId primitive.ObjectID) (*models.Address, error) {
+	addresses, err := ListAddresses(ctx, userCollection, userId)
+	if err != nil {
+		return nil, err
+	}
+	for _, address := range addresses {
+		if address.AddressId == addressId {
+			return &address, nil
+		}
+	}
+	return nil, ErrAddressNotFound
+}
+
+// AddAddress appends address to the address book. The first address becomes the default
+// for both shipping and billing.
+func AddAddress(ctx context.Context, userCollection *mongo.Collection, userId string, address models.Address) (*models.Address, error) {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrUserIdIsNotValid
+	}
+
+	existing, err := ListAddresses(ctx, userCollection, userId)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) == 0 {
+		address.DefaultShipping, address.DefaultBilling = true, true
+	}
+	address.AddressId = primitive.NewObjectID()
+
+	// the new address is a $literal so that user input is never read as an expression
+	addressBook := bson.M{"$concatArrays": bson.A{clearedDefaults(address), bson.A{bson.M{"$literal": address}}}}
+	if err := updateAddressBook(ctx, userCollection, bson.M{"_id": id}, addressBook); err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+// UpdateAddress replaces the address with the same AddressId
+func UpdateAddress(ctx context.Context, userCollection *mongo.Collection, userId string, address models.Address) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	addressBook := bson.M{"$map": bson.M{
+		"input": clearedDefaults(address),
+		"as":    "a",
+		"in": bson.M{"$cond": bson.A{
+			bson.M{"$eq": bson.A{"$$a._id", address.AddressId}},
+			bson.M{"$literal": address},
+			"$$a",
+		}},
+	}}
+	return updateAddressBook(ctx, userCollection, bson.M{"_id": id, "addressDetails._id": address.AddressId}, addressBook)
+}
+
+// DeleteAddress removes an address. When it was a default, the first remaining address
+// takes over that role.
+func DeleteAddress(ctx context.Context, userCollection *mongo.Collection, userId string, addressId primitive.ObjectID) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	remaining := bson.M{"$filter": bson.M{
+		"input": "$addressDetails",
+		"as":    "a",
+		"cond":  bson.M{"$ne": bson.A{"$$a._id", addressId}},
+	}}
+	if err := updateAddressBook(ctx, userCollection, bson.M{"_id": id, "addressDetails._id": addressId}, remaining); err != nil {
+		return err
+	}
+
+	addresses, err := ListAddresses(ctx, userCollection, userId)
+	if err != nil || len(addresses) == 0 {
+		return err
+	}
+	first := addresses[0]
+	promote := false
+	if !hasDefault(addresses, func(a models.Address) bool { return a.DefaultShipping }) {
+		first.DefaultShipping, promote = true, true
+	}
+	if !hasDefault(addresses, func(a models.Address) bool { return a.DefaultBilling }) {
+		first.DefaultBilling, promote = true, true
+	}
+	if !promote {
+		return nil
+	}
+	return UpdateAddress(ctx, userCollection, userId, first)
+}
+
+func hasDefault(addresses []models.Address, isDefault func(models.Address) bool) bool {
+	for _, address := range addresses {
+		if isDefault(address) {
+			return true
+		}
+	}
+	return false
+}
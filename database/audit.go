@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrCantRecordAudit = errors.New("cant record audit entry")
+
+// Retrieves the audit log from the database
+func AuditData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return collection
+}
+
+// RecordAudit appends an entry to the audit log. Callers acting on behalf of
+// another user must not proceed when this fails.
+func RecordAudit(ctx context.Context, auditCollection *mongo.Collection, entry models.AuditEntry) error {
+	entry.ID = primitive.NewObjectID()
+	if entry.CreatedAt.IsZero() {
+		entry.CreatedAt = time.Now()
+	}
+
+	if _, err := auditCollection.InsertOne(ctx, entry); err != nil {
+		log.Println(err)
+		return ErrCantRecordAudit
+	}
+	return nil
+}
@@ -5,11 +5,11 @@ import (
 	"errors"
 	"go-ecommerce/models"
 	"log"
-	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var (
@@ -20,9 +20,10 @@ var (
 	ErrCantRemoveItemCart = errors.New("cant remove item from cart")
 	ErrCantGetItem        = errors.New("cant get item")
 	ErrCantBuyCartItem    = errors.New("cant buy cart item")
+	ErrCartChanged        = errors.New("the cart changed during checkout, please try again")
 )
 
-func AddToCart(ctx context.Context, prodCollection, userCollection *mongo.Collection, productId string) error {
+func AddToCart(ctx context.Context, prodCollection, userCollection, outboxCollection *mongo.Collection, productId primitive.ObjectID, userId string) error {
 	searchFromDb, err := prodCollection.Find(ctx, bson.M{"_id": productId})
 	if err != nil {
 		log.Println(err)
@@ -36,7 +37,7 @@ func AddToCart(ctx context.Context, prodCollection, userCollection *mongo.Collec
 		return ErrCantDecodeProduct
 	}
 
-	id, err := primitive.ObjectIDFromHex(productId)
+	id, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
 		log.Println(err)
 		return ErrUserIdIsNotValid
@@ -45,7 +46,10 @@ func AddToCart(ctx context.Context, prodCollection, userCollection *mongo.Collec
 	filter := bson.D{primitive.E{Key: "_id", Value: id}}
 	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "userCart", Value: bson.D{{Key: "$each", Value: productCart}}}}}}
 
-	_, err = userCollection.UpdateOne(ctx, filter, update)
+	err = WithEvents(ctx, outboxCollection, func(ctx context.Context) error {
+		_, err := userCollection.UpdateOne(ctx, filter, update)
+		return err
+	}, models.NewEvent(models.EventCartUpdated, userId, userId))
 	if err != nil {
 		log.Println(err)
 		return ErrCantUpdateUser
@@ -54,7 +58,7 @@ func AddToCart(ctx context.Context, prodCollection, userCollection *mongo.Collec
 
 }
 
-func RemoveItemFromCart(ctx context.Context, prodCollection, userCollection *mongo.Collection, productId primitive.ObjectID, userId string) error {
+func RemoveItemFromCart(ctx context.Context, prodCollection, userCollection, outboxCollection *mongo.Collection, productId primitive.ObjectID, userId string) error {
 	id, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
 		log.Println(err)
@@ -63,7 +67,10 @@ func RemoveItemFromCart(ctx context.Context, prodCollection, userCollection *mon
 	filter := bson.D{primitive.E{Key: "_id", Value: id}}
 	// remove a particular item from the cart list of the user
 	update := bson.M{"$pull": bson.M{"userCart": bson.M{"_id": productId}}}
-	_, err = userCollection.UpdateMany(ctx, filter, update)
+	err = WithEvents(ctx, outboxCollection, func(ctx context.Context) error {
+		_, err := userCollection.UpdateMany(ctx, filter, update)
+		return err
+	}, models.NewEvent(models.EventCartUpdated, userId, userId))
 
 	if err != nil {
 		log.Println(err)
@@ -73,123 +80,92 @@ func RemoveItemFromCart(ctx context.Context, prodCollection, userCollection *mon
 	return nil
 }
 
-// func GetItemFromCart() gin.HandlerFunc {
-
-// }
-func BuyItemFromCart(ctx context.Context, userCollection *mongo.Collection, userId string) error {
-	// fetch the cart of the user
-	// find the cart total
-	// create an order with the items
-	// add order to the user collection
-	// add items in the cart to list of user orders
-	// empty the cart
-
+// CartItems returns the products in the cart of the user. A product added several
+// times appears several times.
+func CartItems(ctx context.Context, userCollection *mongo.Collection, userId string) ([]models.UserProduct, error) {
 	id, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
 		log.Println(err)
-		return ErrUserIdIsNotValid
-	}
-
-	var getCartItems models.User
-	var orderCart models.Order
-
-	orderCart.OrderId = primitive.NewObjectID()
-	orderCart.OrderedAt = time.Now()
-	orderCart.OrderCart = make([]models.UserProduct, 0)
-	orderCart.PaymentMethod.COD = true
-
-	// add all user products in cart
-	// unwind gives us access to each value in cart
-	// path tells us what we want to unwind
-	unwind := bson.D{{Key: "$unwind", Value: bson.D{primitive.E{Key: "path", Value: "$userCart"}}}}
-	//group by id and find the total which is the sum of all product prices in cart
-	grouping := bson.D{{Key: "$group", Value: bson.D{primitive.E{Key: "_id", Value: "$_id"}, {Key: "total", Value: bson.D{primitive.E{Key: "$sum", Value: "$userCart.price"}}}}}}
-	currResults, err := userCollection.Aggregate(ctx, mongo.Pipeline{unwind, grouping})
-	ctx.Done()
-
-	if err != nil {
-		panic(err)
-	}
-
-	// create an order with the items
-	var getUserCart []bson.M
-	if err = currResults.All(ctx, &getUserCart); err != nil {
-		panic(err)
-	}
-
-	var totalPrice int32
-
-	for _, userItem := range getUserCart {
-		price := userItem["total"]
-		totalPrice += price.(int32)
+		return nil, ErrUserIdIsNotValid
 	}
 
-	orderCart.Price = int(totalPrice)
-
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "orders", Value: orderCart}}}}
-	if _, err = userCollection.UpdateMany(ctx, filter, update); err != nil {
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"userCart": 1})
+	if err = userCollection.FindOne(ctx, bson.M{"_id": id}, opts).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
 		log.Println(err)
+		return nil, ErrCantGetItem
 	}
-
-	if err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&getCartItems); err != nil {
-		log.Println(err)
+	if user.UserCart == nil {
+		return make([]models.UserProduct, 0), nil
 	}
-
-	// add the items in the cart to the order to make a list of orders he has ordered
-	filter2 := bson.D{primitive.E{Key: "_id", Value: id}}
-	update2 := bson.M{"$push": bson.M{"orders.$[].orderList": bson.M{"$each": getCartItems.UserCart}}}
-	if _, err = userCollection.UpdateOne(ctx, filter2, update2); err != nil {
-		log.Println(err)
-	}
-
-	// clear cart
-	userCartEmpty := make([]models.UserProduct, 0)
-	filter3 := bson.D{primitive.E{Key: "_id", Value: id}}
-	update3 := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "userCart", Value: userCartEmpty}}}}
-
-	if _, err = userCollection.UpdateOne(ctx, filter3, update3); err != nil {
-		log.Println(err)
-		return ErrCantBuyCartItem
-	}
-	return nil
+	return user.UserCart, nil
 }
 
-func InstantBuy(ctx context.Context, prodCollection, userCollection *mongo.Collection, productId primitive.ObjectID, userId string) error {
-	// create an order
+// BuyItemFromCart places order, which was planned from the cart contents in cart, and
+// empties the cart. It must run inside WithEvents: if the swapped out cart no longer
+// matches cart, ErrCartChanged aborts the transaction, which puts the cart back, so
+// nothing is bought that was not seen. Other errors are returned as they are so the
+// transaction can retry on transient ones.
+func BuyItemFromCart(ctx context.Context, userCollection, orderCollection *mongo.Collection, userId string, cart []models.UserProduct, order models.Order) error {
 	id, err := primitive.ObjectIDFromHex(userId)
 	if err != nil {
 		log.Println(err)
 		return ErrUserIdIsNotValid
 	}
-	var productDetails models.UserProduct
-	var ordersDetail models.Order
 
-	ordersDetail.OrderId = primitive.NewObjectID()
-	ordersDetail.OrderedAt = time.Now()
-	ordersDetail.OrderCart = make([]models.UserProduct, 0)
-	ordersDetail.PaymentMethod.COD = true
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "userCart", Value: make([]models.UserProduct, 0)}}}}
+	opts := options.FindOneAndUpdate().SetProjection(bson.M{"userCart": 1}).SetReturnDocument(options.Before)
 
-	// use the product id and find the product from the db
-	if err = prodCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: productId}}).Decode(&productDetails); err != nil {
-		log.Println(err)
+	var before models.User
+	if err = userCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&before); err != nil {
+		return err
+	}
+	if !sameCart(before.UserCart, cart) {
+		return ErrCartChanged
 	}
+	_, err = orderCollection.InsertOne(ctx, order)
+	return err
+}
 
-	ordersDetail.Price = productDetails.Price
+// InstantBuy places an order for a single product without going through the cart. Like
+// BuyItemFromCart it runs inside WithEvents and returns errors as they are.
+func InstantBuy(ctx context.Context, orderCollection *mongo.Collection, order models.Order) error {
+	_, err := orderCollection.InsertOne(ctx, order)
+	return err
+}
 
-	filter := bson.D{primitive.E{Key: "_id", Value: id}}
-	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "order", Value: ordersDetail}}}}
-	if _, err = userCollection.UpdateOne(ctx, filter, update); err != nil {
-		log.Println(err)
+// FindProduct loads a product as it is put into a cart or order
+func FindProduct(ctx context.Context, prodCollection *mongo.Collection, productId primitive.ObjectID) (*models.UserProduct, error) {
+	var product models.UserProduct
+	if err := prodCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: productId}}).Decode(&product); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println(err)
+		}
+		return nil, ErrCantFindProduct
 	}
+	return &product, nil
+}
 
-	filter2 := bson.D{primitive.E{Key: "_id", Value: id}}
-	update2 := bson.M{"$push": bson.M{"orders.$[].orderList": productDetails}}
-	if _, err = userCollection.UpdateOne(ctx, filter2, update2); err != nil {
-		log.Println(err)
+// sameCart reports whether both carts hold the same products in the same quantities
+func sameCart(a, b []models.UserProduct) bool {
+	if len(a) != len(b) {
+		return false
 	}
-
-	return nil
-
+	counts := make(map[primitive.ObjectID]int)
+	for _, product := range a {
+		counts[product.ProductId]++
+	}
+	for _, product := range b {
+		counts[product.ProductId]--
+	}
+	for _, count := range counts {
+		if count != 0 {
+			return false
+		}
+	}
+	return true
 }
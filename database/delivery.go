@@ -0,0 +1,44 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var ErrCantRecordDelivery = errors.New("cant record email delivery")
+
+// Retrieves the email delivery log from the database
+func DeliveryData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return collection
+}
+
+// RecordDelivery appends the outcome of sending an email to the delivery log
+func RecordDelivery(ctx context.Context, deliveryCollection *mongo.Collection, delivery models.EmailDelivery) error {
+	delivery.ID = primitive.NewObjectID()
+	if _, err := deliveryCollection.InsertOne(ctx, delivery); err != nil {
+		log.Println(err)
+		return ErrCantRecordDelivery
+	}
+	return nil
+}
+
+// DeleteDeliveries removes the delivery log of the user, including messages logged
+// before deliveries carried a user id, which are found by addresses
+func DeleteDeliveries(ctx context.Context, deliveryCollection *mongo.Collection, userId string, addresses []string) error {
+	filter := bson.M{"$or": bson.A{
+		bson.M{"userId": userId},
+		bson.M{"to": bson.M{"$in": addresses}},
+	}}
+	if _, err := deliveryCollection.DeleteMany(ctx, filter); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
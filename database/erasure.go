@@ -0,0 +1,136 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrNoDeletionScheduled = errors.New("no account deletion is scheduled")
+
+// ScheduleDeletion marks the account to be erased at dueAt
+func ScheduleDeletion(ctx context.Context, userCollection *mongo.Collection, userId string, dueAt time.Time) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	filter := bson.M{"_id": id, "deletedAt": nil}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "deletionDueAt", Value: dueAt}}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// CancelDeletion keeps an account that was scheduled for deletion
+func CancelDeletion(ctx context.Context, userCollection *mongo.Collection, userId string) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	filter := bson.M{"_id": id, "deletedAt": nil, "deletionDueAt": bson.M{"$ne": nil}}
+	update := bson.D{{Key: "$unset", Value: bson.D{primitive.E{Key: "deletionDueAt", Value: ""}}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrNoDeletionScheduled
+	}
+	return nil
+}
+
+// DeletionsDue returns the ids of accounts whose grace period has ended
+func DeletionsDue(ctx context.Context, userCollection *mongo.Collection, now time.Time) ([]string, error) {
+	filter := bson.M{"deletedAt": nil, "deletionDueAt": bson.M{"$lte": now}}
+	cursor, err := userCollection.Find(ctx, filter, options.Find().SetProjection(bson.M{"userid": 1}))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	var users []models.User
+	if err = cursor.All(ctx, &users); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	userIds := make([]string, 0, len(users))
+	for _, user := range users {
+		userIds = append(userIds, user.UserId)
+	}
+	return userIds, nil
+}
+
+// AnonymizeUser replaces the user document with the minimum kept for accounting: its
+// ids, creation date and any orders from before orders had their own collection.
+// Everything that identifies the person is dropped. The fields are listed explicitly so
+// that anything added to User later is dropped too.
+func AnonymizeUser(ctx context.Context, userCollection *mongo.Collection, userId string) error {
+	user, err := FindUserById(ctx, userCollection, userId)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	retained := bson.D{
+		primitive.E{Key: "_id", Value: user.ID},
+		{Key: "userid", Value: user.UserId},
+		{Key: "role", Value: models.RoleCustomer},
+		{Key: "orders", Value: user.OrderStatus},
+		{Key: "userCart", Value: bson.A{}},
+		{Key: "addressDetails", Value: bson.A{}},
+		{Key: "createdat", Value: user.CreatedAt},
+		{Key: "updatedat", Value: now},
+		{Key: "deletedAt", Value: now},
+	}
+	if _, err := userCollection.ReplaceOne(ctx, bson.M{"_id": user.ID}, retained); err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	return nil
+}
+
+// AnonymizeInvoices removes the customer's email from invoices issued while invoices
+// still carried it. Name and billing address stay, since accounting must keep them.
+func AnonymizeInvoices(ctx context.Context, invoiceCollection *mongo.Collection, userId string) error {
+	filter := bson.M{"userId": userId, "customerEmail": bson.M{"$exists": true}}
+	if _, err := invoiceCollection.UpdateMany(ctx, filter, bson.M{"$unset": bson.M{"customerEmail": ""}}); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// AnonymizeOrders strips the destination addresses of the user's orders down to the
+// country, which accounting needs for taxes
+func AnonymizeOrders(ctx context.Context, orderCollection *mongo.Collection, userId string) error {
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"destinations": bson.M{"$map": bson.M{
+			"input": bson.M{"$ifNull": bson.A{"$destinations", bson.A{}}},
+			"as":    "d",
+			"in":    bson.M{"$mergeObjects": bson.A{"$$d", bson.M{"address": bson.M{"country": "$$d.address.country"}}}},
+		}},
+	}}}}
+	if _, err := orderCollection.UpdateMany(ctx, bson.M{"userId": userId}, update); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrOutOfStock      = errors.New("not enough items in stock")
+	ErrCantUpdateStock = errors.New("cant update stock")
+)
+
+// ReserveStock takes the quantities of the order lines out of stock. A product is only
+// decremented while it has enough left, so stock never goes negative; if any product
+// runs short, nothing is taken and ErrOutOfStock is returned. Products that do not
+// track stock are left alone. Every product whose stock changes gets a ProductChanged
+// event in the same transaction.
+func ReserveStock(ctx context.Context, prodCollection, outboxCollection *mongo.Collection, lines []models.LineItem) error {
+	err := WithEvents(ctx, outboxCollection, func(ctx context.Context) error {
+		changed := make([]primitive.ObjectID, 0, len(lines))
+		for _, line := range lines {
+			filter := bson.M{"_id": line.ProductId, "stock": bson.M{"$gte": line.Quantity}}
+			result, err := prodCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"stock": -line.Quantity}})
+			if err != nil {
+				return err
+			}
+			if result.MatchedCount > 0 {
+				changed = append(changed, line.ProductId)
+				continue
+			}
+			tracked, err := prodCollection.CountDocuments(ctx, bson.M{"_id": line.ProductId, "stock": bson.M{"$exists": true}})
+			if err != nil {
+				return err
+			}
+			if tracked > 0 {
+				return ErrOutOfStock
+			}
+		}
+		return RecordEvents(ctx, outboxCollection, productChanged(changed)...)
+	})
+	if err != nil && err != ErrOutOfStock {
+		log.Println(err)
+		return ErrCantUpdateStock
+	}
+	return err
+}
+
+// ReleaseStock puts the quantities of the order lines back into stock, with a
+// ProductChanged event for every product whose stock changes
+func ReleaseStock(ctx context.Context, prodCollection, outboxCollection *mongo.Collection, lines []models.LineItem) error {
+	err := WithEvents(ctx, outboxCollection, func(ctx context.Context) error {
+		changed, err := releaseStock(ctx, prodCollection, lines)
+		if err != nil {
+			return err
+		}
+		return RecordEvents(ctx, outboxCollection, productChanged(changed)...)
+	})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateStock
+	}
+	return nil
+}
+
+// releaseStock puts the quantities of the order lines back into stock and returns the
+// products whose stock changed. Errors are returned as they are, for use inside
+// WithEvents.
+func releaseStock(ctx context.Context, prodCollection *mongo.Collection, lines []models.LineItem) ([]primitive.ObjectID, error) {
+	changed := make([]primitive.ObjectID, 0, len(lines))
+	for _, line := range lines {
+		if line.Quantity <= 0 {
+			continue
+		}
+		filter := bson.M{"_id": line.ProductId, "stock": bson.M{"$exists": true}}
+		result, err := prodCollection.UpdateOne(ctx, filter, bson.M{"$inc": bson.M{"stock": line.Quantity}})
+		if err != nil {
+			return nil, err
+		}
+		if result.MatchedCount > 0 {
+			changed = append(changed, line.ProductId)
+		}
+	}
+	return changed, nil
+}
+
+// productChanged makes one ProductChanged event per product, however many lines it has
+func productChanged(productIds []primitive.ObjectID) []models.Event {
+	seen := make(map[primitive.ObjectID]bool)
+	events := make([]models.Event, 0, len(productIds))
+	for _, productId := range productIds {
+		if !seen[productId] {
+			seen[productId] = true
+			events = append(events, models.NewEvent(models.EventProductChanged, productId.Hex(), ""))
+		}
+	}
+	return events
+}
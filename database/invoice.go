@@ -0,0 +1,156 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go-ecommerce/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// invoiceClaimTimeout is how long an interrupted attempt to issue an invoice blocks the
+// next one
+const invoiceClaimTimeout = time.Minute
+
+var (
+	ErrInvoiceNotFound     = errors.New("invoice not found")
+	ErrInvoicePending      = errors.New("the invoice is being issued, try again shortly")
+	ErrCantIssueInvoice    = errors.New("cant issue invoice")
+	ErrOrderNotInvoiceable = errors.New("the order has not been paid yet")
+	ErrOrderAlreadyPaid    = errors.New("the order has been paid already")
+)
+
+func InvoiceData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return collection
+}
+
+// IssueInvoice numbers and stores draft as the invoice of its order, unless the order
+// already has one, which is returned instead.
+//
+// Numbers run without gaps per year of draft.IssuedAt. A number only exists as the _id
+// of a stored invoice, and each is taken as one above the highest of the year; if another
+// invoice got that number first the insert fails on the duplicate _id and the next one
+// is tried. While this runs the order is claimed, so it cannot be invoiced twice.
+func IssueInvoice(ctx context.Context, orderCollection, invoiceCollection *mongo.Collection, draft models.Invoice) (*models.Invoice, error) {
+	if existing, err := invoiceForOrder(ctx, orderCollection, invoiceCollection, draft.OrderId); err != nil || existing != nil {
+		return existing, err
+	}
+
+	now := time.Now()
+	claim := bson.M{
+		"_id":           draft.OrderId,
+		"paidAt":        bson.M{"$exists": true},
+		"invoiceNumber": bson.M{"$exists": false},
+		"$or": bson.A{
+			bson.M{"invoiceClaimedAt": bson.M{"$exists": false}},
+			bson.M{"invoiceClaimedAt": bson.M{"$lt": now.Add(-invoiceClaimTimeout)}},
+		},
+	}
+	result, err := orderCollection.UpdateOne(ctx, claim, bson.M{"$set": bson.M{"invoiceClaimedAt": now}})
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantIssueInvoice
+	}
+	if result.MatchedCount == 0 {
+		if existing, err := invoiceForOrder(ctx, orderCollection, invoiceCollection, draft.OrderId); err != nil || existing != nil {
+			return existing, err
+		}
+		return nil, ErrInvoicePending
+	}
+
+	// an earlier attempt may have stored the invoice but not linked it to the order
+	var invoice models.Invoice
+	err = invoiceCollection.FindOne(ctx, bson.M{"orderId": draft.OrderId}).Decode(&invoice)
+	if err == mongo.ErrNoDocuments {
+		invoice, err = insertNumbered(ctx, invoiceCollection, draft)
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, ErrCantIssueInvoice
+	}
+
+	link := bson.M{"$set": bson.M{"invoiceNumber": invoice.Number}, "$unset": bson.M{"invoiceClaimedAt": ""}}
+	if _, err = orderCollection.UpdateOne(ctx, bson.M{"_id": draft.OrderId}, link); err != nil {
+		log.Println(err)
+		return nil, ErrCantIssueInvoice
+	}
+	return &invoice, nil
+}
+
+// insertNumbered stores draft under the next free number of its year
+func insertNumbered(ctx context.Context, invoiceCollection *mongo.Collection, draft models.Invoice) (models.Invoice, error) {
+	draft.Year = draft.IssuedAt.Year()
+	for attempt := 0; attempt < 20; attempt++ {
+		var last models.Invoice
+		opts := options.FindOne().SetSort(bson.M{"sequence": -1}).SetProjection(bson.M{"sequence": 1})
+		err := invoiceCollection.FindOne(ctx, bson.M{"year": draft.Year}, opts).Decode(&last)
+		if err != nil && err != mongo.ErrNoDocuments {
+			return draft, err
+		}
+
+		draft.Sequence = last.Sequence + 1
+		draft.Number = fmt.Sprintf("%d-%06d", draft.Year, draft.Sequence)
+		_, err = invoiceCollection.InsertOne(ctx, draft)
+		if err == nil {
+			return draft, nil
+		}
+		if !mongo.IsDuplicateKeyError(err) {
+			return draft, err
+		}
+	}
+	return draft, ErrCantIssueInvoice
+}
+
+// invoiceForOrder returns the invoice already linked to the order, or nil
+func invoiceForOrder(ctx context.Context, orderCollection, invoiceCollection *mongo.Collection, orderId primitive.ObjectID) (*models.Invoice, error) {
+	var order models.Order
+	opts := options.FindOne().SetProjection(bson.M{"invoiceNumber": 1, "paidAt": 1})
+	if err := orderCollection.FindOne(ctx, bson.M{"_id": orderId}, opts).Decode(&order); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrOrderNotFound
+		}
+		log.Println(err)
+		return nil, err
+	}
+	if order.PaidAt == nil {
+		return nil, ErrOrderNotInvoiceable
+	}
+	if order.InvoiceNumber == "" {
+		return nil, nil
+	}
+	return FindInvoice(ctx, invoiceCollection, order.InvoiceNumber)
+}
+
+func FindInvoice(ctx context.Context, invoiceCollection *mongo.Collection, number string) (*models.Invoice, error) {
+	var invoice models.Invoice
+	if err := invoiceCollection.FindOne(ctx, bson.M{"_id": number}).Decode(&invoice); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrInvoiceNotFound
+		}
+		log.Println(err)
+		return nil, err
+	}
+	return &invoice, nil
+}
+
+// MarkOrderPaid records when a cash on delivery order was paid. It returns
+// ErrOrderAlreadyPaid when the order was marked paid before.
+func MarkOrderPaid(ctx context.Context, orderCollection *mongo.Collection, orderId primitive.ObjectID, paidAt time.Time) error {
+	filter := bson.M{"_id": orderId, "paidAt": bson.M{"$exists": false}}
+	result, err := orderCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"paidAt": paidAt}})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrOrderAlreadyPaid
+	}
+	return nil
+}
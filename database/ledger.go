@@ -0,0 +1,209 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrRefundExceedsPayment = errors.New("the refund exceeds what was paid and not yet refunded")
+	ErrCantRecordRefund     = errors.New("cant record refund")
+	ErrRefundSettled        = errors.New("the refund was already settled")
+	ErrLedgerEntryNotFound  = errors.New("ledger entry not found")
+)
+
+func LedgerData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return collection
+}
+
+// refundUpdate builds the update adding sign times amount to the refunded amount of the
+// order and of each line in lines, with the array filters it needs
+func refundUpdate(amount int, lines []models.RefundLine, sign int) (bson.M, *options.UpdateOptions) {
+	increments, arrayFilters := lineIncrements("refundedAmount", lineRefunds(lines, sign))
+	increments["refundedAmount"] = sign * amount
+	opts := options.Update()
+	if len(arrayFilters) > 0 {
+		opts.SetArrayFilters(options.ArrayFilters{Filters: arrayFilters})
+	}
+	return bson.M{"$inc": increments}, opts
+}
+
+// lineRefunds adds up sign times the amounts of lines per order line
+func lineRefunds(lines []models.RefundLine, sign int) map[primitive.ObjectID]int {
+	amounts := make(map[primitive.ObjectID]int)
+	for _, line := range lines {
+		amounts[line.LineId] += sign * line.Amount
+	}
+	return amounts
+}
+
+// ReserveRefund writes down entry, a pending refund of order, and counts its amount as
+// refunded on the order, and each of its lines on its line, in one transaction before
+// any money moves. The reservation only lands while neither the order's captured amount
+// nor any line's total would be exceeded, so concurrent refunds can never add up to
+// more than was paid. SettleRefund completes the entry, or fails it and releases the
+// reservation together with ReleaseRefund.
+func ReserveRefund(ctx context.Context, orderCollection, ledgerCollection, outboxCollection *mongo.Collection, order models.Order, entry models.LedgerEntry) error {
+	captured := order.CapturedAmount()
+	if entry.Amount <= 0 || entry.Amount > captured {
+		return ErrRefundExceedsPayment
+	}
+
+	totals := make(map[primitive.ObjectID]int)
+	for _, line := range order.LineItems {
+		totals[line.LineId] = line.LineTotal()
+	}
+	conditions := bson.A{bson.M{"$or": bson.A{
+		bson.M{"refundedAmount": bson.M{"$lte": captured - entry.Amount}},
+		bson.M{"refundedAmount": bson.M{"$exists": false}},
+	}}}
+	for _, line := range entry.Lines {
+		if line.Amount <= 0 {
+			return ErrRefundExceedsPayment
+		}
+	}
+	for lineId, amount := range lineRefunds(entry.Lines, 1) {
+		total, ok := totals[lineId]
+		if !ok || amount > total {
+			return ErrRefundExceedsPayment
+		}
+		conditions = append(conditions, lineAtMost(lineId, "refundedAmount", total-amount))
+	}
+
+	// what was captured must still hold when the update lands
+	filter := bson.M{"_id": order.OrderId, "$and": conditions}
+	if order.Charge != nil {
+		filter["charge.status"] = bson.M{"$ne": models.ChargeVoided}
+	} else {
+		filter["paidAt"] = bson.M{"$exists": true}
+	}
+	update, opts := refundUpdate(entry.Amount, entry.Lines, 1)
+	err := WithEvents(ctx, outboxCollection, func(ctx context.Context) error {
+		result, err := orderCollection.UpdateOne(ctx, filter, update, opts)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrRefundExceedsPayment
+		}
+		_, err = ledgerCollection.InsertOne(ctx, entry)
+		return err
+	})
+	if err != nil && err != ErrRefundExceedsPayment {
+		log.Println(err)
+		return ErrCantRecordRefund
+	}
+	return err
+}
+
+// SettleRefund moves a pending refund entry to status, completed or failed, recording
+// the provider's refund id if there is one. It fails with ErrRefundSettled when the entry
+// is no longer pending, so a refund is settled only once. Other errors are returned as
+// they are, for use inside WithEvents.
+func SettleRefund(ctx context.Context, ledgerCollection *mongo.Collection, entryId primitive.ObjectID, status, transactionId string) error {
+	set := bson.M{"status": status}
+	if transactionId != "" {
+		set["transactionId"] = transactionId
+	}
+	result, err := ledgerCollection.UpdateOne(ctx, bson.M{"_id": entryId, "status": models.LedgerPending}, bson.M{"$set": set})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrRefundSettled
+	}
+	return nil
+}
+
+// ReleaseRefund gives back a reservation made by ReserveRefund. Errors are returned as
+// they are, for use inside WithEvents.
+func ReleaseRefund(ctx context.Context, orderCollection *mongo.Collection, orderId primitive.ObjectID, amount int, lines []models.RefundLine) error {
+	update, opts := refundUpdate(amount, lines, -1)
+	if _, err := orderCollection.UpdateOne(ctx, bson.M{"_id": orderId}, update, opts); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+func RecordLedgerEntry(ctx context.Context, ledgerCollection *mongo.Collection, entry models.LedgerEntry) error {
+	if _, err := ledgerCollection.InsertOne(ctx, entry); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// ListLedger returns the ledger entries of an order, oldest first
+func ListLedger(ctx context.Context, ledgerCollection *mongo.Collection, orderId primitive.ObjectID) ([]models.LedgerEntry, error) {
+	cursor, err := ledgerCollection.Find(ctx, bson.M{"orderId": orderId}, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	entries := make([]models.LedgerEntry, 0)
+	if err = cursor.All(ctx, &entries); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return entries, nil
+}
+
+func FindLedgerEntry(ctx context.Context, ledgerCollection *mongo.Collection, entryId string) (*models.LedgerEntry, error) {
+	id, err := primitive.ObjectIDFromHex(entryId)
+	if err != nil {
+		return nil, ErrLedgerEntryNotFound
+	}
+
+	var entry models.LedgerEntry
+	if err = ledgerCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&entry); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrLedgerEntryNotFound
+		}
+		log.Println(err)
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// FindReturnRefund returns the refund already made for a return, or nil. Pending refunds
+// count, since their money may already have moved.
+func FindReturnRefund(ctx context.Context, ledgerCollection *mongo.Collection, returnId primitive.ObjectID) (*models.LedgerEntry, error) {
+	var entry models.LedgerEntry
+	filter := bson.M{"returnId": returnId, "kind": models.LedgerRefund, "status": bson.M{"$ne": models.LedgerFailed}}
+	err := ledgerCollection.FindOne(ctx, filter).Decode(&entry)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return &entry, nil
+}
+
+// AddStoreCredit adds amount to the store credit balance of the user
+func AddStoreCredit(ctx context.Context, userCollection *mongo.Collection, userId string, amount int) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		return ErrUserIdIsNotValid
+	}
+	result, err := userCollection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{"$inc": bson.M{"storeCredit": amount}})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
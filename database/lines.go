@@ -0,0 +1,32 @@
+package database
+
+import (
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// lineAtMost matches orders whose line lineId has field at most max, counting a line
+// without the field as 0
+func lineAtMost(lineId primitive.ObjectID, field string, max int) bson.M {
+	atMost := bson.A{bson.M{field: bson.M{"$lte": max}}}
+	if max >= 0 {
+		atMost = append(atMost, bson.M{field: bson.M{"$exists": false}})
+	}
+	return bson.M{"lineItems": bson.M{"$elemMatch": bson.M{"_id": lineId, "$or": atMost}}}
+}
+
+// lineIncrements builds the $inc fields adding each of deltas to field of its order
+// line, with the array filters that pick the lines out. Keying the deltas by line means
+// no line can be updated twice.
+func lineIncrements(field string, deltas map[primitive.ObjectID]int) (bson.M, []interface{}) {
+	increments := bson.M{}
+	arrayFilters := make([]interface{}, 0, len(deltas))
+	for lineId, delta := range deltas {
+		name := fmt.Sprintf("l%d", len(arrayFilters))
+		increments["lineItems.$["+name+"]."+field] = delta
+		arrayFilters = append(arrayFilters, bson.M{name + "._id": lineId})
+	}
+	return increments, arrayFilters
+}
@@ -0,0 +1,92 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrTOTPCodeReused      = errors.New("code has already been used")
+	ErrInvalidRecoveryCode = errors.New("invalid recovery code")
+)
+
+// SetPendingTOTPSecret stores a secret awaiting confirmation by a first valid code
+func SetPendingTOTPSecret(ctx context.Context, userCollection *mongo.Collection, userId, secret string) error {
+	return updateUserById(ctx, userCollection, userId, bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "totpPendingSecret", Value: secret}}}})
+}
+
+// EnableTOTP promotes the confirmed secret and stores the hashed recovery codes
+func EnableTOTP(ctx context.Context, userCollection *mongo.Collection, userId, secret string, step int64, recoveryCodeHashes []string) error {
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			primitive.E{Key: "totpEnabled", Value: true},
+			{Key: "totpSecret", Value: secret},
+			{Key: "totpLastStep", Value: step},
+			{Key: "recoveryCodes", Value: recoveryCodeHashes},
+		}},
+		{Key: "$unset", Value: bson.D{primitive.E{Key: "totpPendingSecret", Value: ""}}},
+	}
+	return updateUserById(ctx, userCollection, userId, update)
+}
+
+// RecordTOTPStep remembers the time step of an accepted code. It fails when that step
+// or a later one was already used, so every code works at most once.
+func RecordTOTPStep(ctx context.Context, userCollection *mongo.Collection, userId string, step int64) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	filter := bson.M{"_id": id, "totpLastStep": bson.M{"$lt": step}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "totpLastStep", Value: step}}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrTOTPCodeReused
+	}
+	return nil
+}
+
+// UseRecoveryCode removes a recovery code hash from the user, failing if it is unknown
+func UseRecoveryCode(ctx context.Context, userCollection *mongo.Collection, userId, codeHash string) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	filter := bson.M{"_id": id, "recoveryCodes": codeHash}
+	update := bson.M{"$pull": bson.M{"recoveryCodes": codeHash}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrInvalidRecoveryCode
+	}
+	return nil
+}
+
+func updateUserById(ctx context.Context, userCollection *mongo.Collection, userId string, update interface{}) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	if _, err = userCollection.UpdateOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	return nil
+}
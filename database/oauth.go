@@ -0,0 +1,118 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrCantSaveOAuthState  = errors.New("cant save login state")
+	ErrInvalidOAuthState   = errors.New("login state is invalid or has expired")
+	ErrIdentityAlreadyUsed = errors.New("this external account is linked to another user")
+)
+
+// Retrieves pending OpenID Connect logins from the database
+func OAuthStateData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return collection
+}
+
+// SaveOAuthState stores the nonce and PKCE verifier of a login under the hash of its state
+func SaveOAuthState(ctx context.Context, stateCollection *mongo.Collection, state string, oauthState models.OAuthState) error {
+	oauthState.ID = HashActionToken(state)
+	if _, err := stateCollection.InsertOne(ctx, oauthState); err != nil {
+		log.Println(err)
+		return ErrCantSaveOAuthState
+	}
+	return nil
+}
+
+// ConsumeOAuthState removes and returns the pending login for state, so every state can
+// complete at most one login
+func ConsumeOAuthState(ctx context.Context, stateCollection *mongo.Collection, state, provider string) (*models.OAuthState, error) {
+	filter := bson.M{
+		"_id":       HashActionToken(state),
+		"provider":  provider,
+		"expiresAt": bson.M{"$gt": time.Now()},
+	}
+
+	var oauthState models.OAuthState
+	if err := stateCollection.FindOneAndDelete(ctx, filter).Decode(&oauthState); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println(err)
+		}
+		return nil, ErrInvalidOAuthState
+	}
+	return &oauthState, nil
+}
+
+// FindUserByIdentity loads the user linked to subject at provider
+func FindUserByIdentity(ctx context.Context, userCollection *mongo.Collection, provider, subject string) (*models.User, error) {
+	filter := bson.M{"identities": bson.M{"$elemMatch": bson.M{"provider": provider, "subject": subject}}}
+
+	var user models.User
+	if err := userCollection.FindOne(ctx, filter).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		log.Println(err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+// LinkIdentity adds identity to the user. An external account can only ever be linked
+// to one user.
+func LinkIdentity(ctx context.Context, userCollection *mongo.Collection, userId string, identity models.ExternalIdentity) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	if existing, err := FindUserByIdentity(ctx, userCollection, identity.Provider, identity.Subject); err == nil {
+		if existing.UserId != userId {
+			return ErrIdentityAlreadyUsed
+		}
+		return nil
+	} else if err != ErrUserNotFound {
+		return err
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+	update := bson.D{{Key: "$push", Value: bson.D{primitive.E{Key: "identities", Value: identity}}}}
+	if _, err = userCollection.UpdateOne(ctx, filter, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	return nil
+}
+
+// ClaimUnverifiedAccount marks the email of an account as verified on behalf of an
+// identity provider and drops its password. Until then anyone could have signed up with
+// that address, so a password set before ownership was proven is not trusted.
+func ClaimUnverifiedAccount(ctx context.Context, userCollection *mongo.Collection, userId string) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: id}, {Key: "emailVerified", Value: bson.M{"$ne": true}}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{primitive.E{Key: "emailVerified", Value: true}, {Key: "emailVerifiedAt", Value: time.Now()}, {Key: "updatedat", Value: time.Now()}}},
+		{Key: "$unset", Value: bson.D{primitive.E{Key: "password", Value: ""}}},
+	}
+	if _, err = userCollection.UpdateOne(ctx, filter, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	return nil
+}
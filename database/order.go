@@ -0,0 +1,143 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrOrderNotFound     = errors.New("order not found")
+	ErrOrderIdIsNotValid = errors.New("order id is not valid")
+)
+
+// Retrieves orders from the database
+func OrderData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return collection
+}
+
+// ListOrders returns the orders of the user, newest first
+func ListOrders(ctx context.Context, orderCollection *mongo.Collection, userId string) ([]models.Order, error) {
+	cursor, err := orderCollection.Find(ctx, bson.M{"userId": userId}, options.Find().SetSort(bson.M{"orderedAt": -1}))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	orders := make([]models.Order, 0)
+	if err = cursor.All(ctx, &orders); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return orders, nil
+}
+
+// FindOrder loads an order. With a userId the order must belong to that user, so
+// customers cannot look up other people's orders; admins pass an empty userId.
+func FindOrder(ctx context.Context, orderCollection *mongo.Collection, orderId, userId string) (*models.Order, error) {
+	id, err := primitive.ObjectIDFromHex(orderId)
+	if err != nil {
+		return nil, ErrOrderIdIsNotValid
+	}
+
+	filter := bson.M{"_id": id}
+	if userId != "" {
+		filter["userId"] = userId
+	}
+
+	var order models.Order
+	if err = orderCollection.FindOne(ctx, filter).Decode(&order); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrOrderNotFound
+		}
+		log.Println(err)
+		return nil, err
+	}
+	return &order, nil
+}
+
+var (
+	ErrOrderNotCancellable      = errors.New("the order can no longer be cancelled, it is already being fulfilled")
+	ErrCancellationWindowClosed = errors.New("the order is too old to be cancelled")
+)
+
+// CancelOrder cancels an order of the user that was placed after placedAfter and has
+// not started shipping. The checks are part of the update, so an order cannot be
+// shipped and cancelled at the same time. Its items go back into stock and an
+// OrderCancelled event is recorded in the same transaction, so the payment is reversed
+// by the event's subscribers even if this process stops. It returns the cancelled order.
+func CancelOrder(ctx context.Context, orderCollection, prodCollection, outboxCollection *mongo.Collection, orderId, userId string, placedAfter time.Time, cancellation models.Cancellation) (*models.Order, error) {
+	order, err := FindOrder(ctx, orderCollection, orderId, userId)
+	if err != nil {
+		return nil, err
+	}
+	if order.Status != models.OrderPlaced {
+		return nil, ErrOrderNotCancellable
+	}
+	if order.OrderedAt.Before(placedAfter) {
+		return nil, ErrCancellationWindowClosed
+	}
+
+	filter := bson.M{
+		"_id":       order.OrderId,
+		"status":    models.OrderPlaced,
+		"orderedAt": bson.M{"$gte": placedAfter},
+		"lineItems": bson.M{"$not": bson.M{"$elemMatch": bson.M{"shippedQuantity": bson.M{"$gt": 0}}}},
+	}
+	update := bson.M{"$set": bson.M{"status": models.OrderCancelled, "cancellation": cancellation}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var cancelled models.Order
+	err = WithEvents(ctx, outboxCollection, func(ctx context.Context) error {
+		if err := orderCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&cancelled); err != nil {
+			if err == mongo.ErrNoDocuments {
+				return ErrOrderNotCancellable
+			}
+			return err
+		}
+		changed, err := releaseStock(ctx, prodCollection, cancelled.LineItems)
+		if err != nil {
+			return err
+		}
+		return RecordEvents(ctx, outboxCollection, productChanged(changed)...)
+	}, models.NewEvent(models.EventOrderCancelled, order.OrderId.Hex(), order.UserId))
+	if err != nil {
+		if err != ErrOrderNotCancellable {
+			log.Println(err)
+		}
+		return nil, err
+	}
+	return &cancelled, nil
+}
+
+// SetOrderCharge stores the current state of the payment of an order
+func SetOrderCharge(ctx context.Context, orderCollection *mongo.Collection, orderId primitive.ObjectID, charge models.Charge) error {
+	if _, err := orderCollection.UpdateOne(ctx, bson.M{"_id": orderId}, bson.M{"$set": bson.M{"charge": charge}}); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// MarkChargeRefunded flags the charge of an order as refunded once refunds have paid
+// back all of it
+func MarkChargeRefunded(ctx context.Context, orderCollection *mongo.Collection, orderId primitive.ObjectID) error {
+	filter := bson.M{
+		"_id":           orderId,
+		"charge.status": models.ChargeCaptured,
+		"$expr":         bson.M{"$gte": bson.A{"$refundedAmount", "$charge.amount"}},
+	}
+	if _, err := orderCollection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"charge.status": models.ChargeRefunded}}); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
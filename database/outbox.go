@@ -0,0 +1,112 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var ErrCantUpdateEvent = errors.New("cant update outbox event")
+
+// Retrieves the outbox of domain events from the database
+func OutboxData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return collection
+}
+
+// WithEvents runs change and appends events to the outbox in one transaction, so the
+// events are stored exactly when the change is. change must do all of its writes with
+// the context it is given and may be run again if the transaction is retried.
+// change must return driver errors as they are, since their TransientTransactionError
+// label is what makes the transaction retry, and its errors are returned as they are, to
+// be mapped to sentinel errors by the caller. Transactions need MongoDB to run as a
+// replica set.
+func WithEvents(ctx context.Context, outboxCollection *mongo.Collection, change func(ctx context.Context) error, events ...models.Event) error {
+	session, err := outboxCollection.Database().Client().StartSession()
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessCtx mongo.SessionContext) (interface{}, error) {
+		if err := change(sessCtx); err != nil {
+			return nil, err
+		}
+		return nil, RecordEvents(sessCtx, outboxCollection, events...)
+	})
+	return err
+}
+
+// RecordEvents appends events to the outbox. Called with the context WithEvents hands to
+// its change, they become part of that transaction, for changes that only know their
+// events once they are made.
+func RecordEvents(ctx context.Context, outboxCollection *mongo.Collection, events ...models.Event) error {
+	documents := make([]interface{}, 0, len(events))
+	for _, event := range events {
+		documents = append(documents, event)
+	}
+	if len(documents) == 0 {
+		return nil
+	}
+	// the error is passed on as is so transient ones retry the transaction
+	_, err := outboxCollection.InsertMany(ctx, documents)
+	return err
+}
+
+// ClaimEvent takes the oldest event that is due, hiding it from other relays for
+// lease. Should the relay stop before finishing it, the event becomes due again once the
+// lease runs out. It returns nil when no event is due.
+func ClaimEvent(ctx context.Context, outboxCollection *mongo.Collection, lease time.Duration) (*models.Event, error) {
+	now := time.Now()
+	filter := bson.M{"dispatchedAt": bson.M{"$exists": false}, "availableAt": bson.M{"$lte": now}}
+	update := bson.M{"$set": bson.M{"availableAt": now.Add(lease)}, "$inc": bson.M{"attempts": 1}}
+	opts := options.FindOneAndUpdate().SetSort(bson.M{"availableAt": 1}).SetReturnDocument(options.After)
+
+	var event models.Event
+	if err := outboxCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&event); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		log.Println(err)
+		return nil, ErrCantUpdateEvent
+	}
+	return &event, nil
+}
+
+// MarkEventHandled records that subscriber has handled the event
+func MarkEventHandled(ctx context.Context, outboxCollection *mongo.Collection, eventId primitive.ObjectID, subscriber string) error {
+	update := bson.M{"$addToSet": bson.M{"handledBy": subscriber}}
+	if _, err := outboxCollection.UpdateOne(ctx, bson.M{"_id": eventId}, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateEvent
+	}
+	return nil
+}
+
+// MarkEventDispatched records that every subscriber has handled the event
+func MarkEventDispatched(ctx context.Context, outboxCollection *mongo.Collection, eventId primitive.ObjectID) error {
+	update := bson.M{"$set": bson.M{"dispatchedAt": time.Now()}, "$unset": bson.M{"lastError": ""}}
+	if _, err := outboxCollection.UpdateOne(ctx, bson.M{"_id": eventId}, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateEvent
+	}
+	return nil
+}
+
+// RetryEvent makes the event due again at availableAt after a subscriber failed
+func RetryEvent(ctx context.Context, outboxCollection *mongo.Collection, eventId primitive.ObjectID, availableAt time.Time, lastError string) error {
+	update := bson.M{"$set": bson.M{"availableAt": availableAt, "lastError": lastError}}
+	if _, err := outboxCollection.UpdateOne(ctx, bson.M{"_id": eventId}, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateEvent
+	}
+	return nil
+}
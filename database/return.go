@@ -0,0 +1,159 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrReturnNotFound      = errors.New("return not found")
+	ErrReturnIdIsNotValid  = errors.New("return id is not valid")
+	ErrReturnExceedsOrder  = errors.New("the return holds more items than were shipped and not yet returned")
+	ErrReturnStatusChanged = errors.New("the return is not in a status that allows this")
+	ErrCantCreateReturn    = errors.New("cant create return")
+	ErrOrderNotReturnable  = errors.New("nothing of the order has shipped yet")
+	ErrReturnWindowClosed  = errors.New("the return window of the order has closed")
+)
+
+func ReturnData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return collection
+}
+
+// returnedQuantityUpdate builds the update adding sign times the quantities of items to
+// the returned quantities of their lines, with the array filters it needs
+func returnedQuantityUpdate(items []models.ReturnItem, sign int) (bson.M, *options.UpdateOptions) {
+	increments, arrayFilters := lineIncrements("returnedQuantity", returnedQuantities(items, sign))
+	return bson.M{"$inc": increments}, options.Update().SetArrayFilters(options.ArrayFilters{Filters: arrayFilters})
+}
+
+// returnedQuantities adds up sign times the quantities of items per line
+func returnedQuantities(items []models.ReturnItem, sign int) map[primitive.ObjectID]int {
+	quantities := make(map[primitive.ObjectID]int)
+	for _, item := range items {
+		quantities[item.LineId] += sign * item.Quantity
+	}
+	return quantities
+}
+
+// CreateReturn records a return request and counts its items as returned on the order.
+// Only items that have shipped and are not in another return can be returned; the check
+// is part of the update so concurrent requests cannot return the same items twice.
+func CreateReturn(ctx context.Context, orderCollection, returnCollection *mongo.Collection, order models.Order, ret models.Return) error {
+	shipped := make(map[primitive.ObjectID]int)
+	for _, line := range order.LineItems {
+		shipped[line.LineId] = line.ShippedQuantity
+	}
+
+	conditions := bson.A{}
+	for _, item := range ret.Items {
+		if item.Quantity <= 0 {
+			return ErrReturnExceedsOrder
+		}
+	}
+	for lineId, quantity := range returnedQuantities(ret.Items, 1) {
+		shippedQuantity, ok := shipped[lineId]
+		if !ok {
+			return ErrReturnExceedsOrder
+		}
+		// shipped quantities only grow, so checking against the loaded ones is safe
+		conditions = append(conditions, lineAtMost(lineId, "returnedQuantity", shippedQuantity-quantity))
+	}
+	if len(conditions) == 0 {
+		return ErrReturnExceedsOrder
+	}
+
+	update, opts := returnedQuantityUpdate(ret.Items, 1)
+	result, err := orderCollection.UpdateOne(ctx, bson.M{"_id": order.OrderId, "$and": conditions}, update, opts)
+	if err != nil {
+		log.Println(err)
+		return ErrCantCreateReturn
+	}
+	if result.MatchedCount == 0 {
+		return ErrReturnExceedsOrder
+	}
+
+	if _, err = returnCollection.InsertOne(ctx, ret); err != nil {
+		log.Println(err)
+		if undoErr := ReleaseReturnedItems(ctx, orderCollection, order.OrderId, ret.Items); undoErr != nil {
+			log.Println("Error undoing returned quantities: ", undoErr)
+		}
+		return ErrCantCreateReturn
+	}
+	return nil
+}
+
+// ReleaseReturnedItems makes the items of a rejected return returnable again
+func ReleaseReturnedItems(ctx context.Context, orderCollection *mongo.Collection, orderId primitive.ObjectID, items []models.ReturnItem) error {
+	update, opts := returnedQuantityUpdate(items, -1)
+	if _, err := orderCollection.UpdateOne(ctx, bson.M{"_id": orderId}, update, opts); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// TransitionReturn moves a return from status from to the values in set, which must
+// include the new status. It fails with ErrReturnStatusChanged when the return is no
+// longer in status from, so each step of the workflow happens only once.
+func TransitionReturn(ctx context.Context, returnCollection *mongo.Collection, returnId, from string, set bson.M) (*models.Return, error) {
+	id, err := primitive.ObjectIDFromHex(returnId)
+	if err != nil {
+		return nil, ErrReturnIdIsNotValid
+	}
+
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+	var ret models.Return
+	err = returnCollection.FindOneAndUpdate(ctx, bson.M{"_id": id, "status": from}, bson.M{"$set": set}, opts).Decode(&ret)
+	if err == mongo.ErrNoDocuments {
+		if _, err = FindReturn(ctx, returnCollection, returnId); err != nil {
+			return nil, err
+		}
+		return nil, ErrReturnStatusChanged
+	}
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return &ret, nil
+}
+
+func FindReturn(ctx context.Context, returnCollection *mongo.Collection, returnId string) (*models.Return, error) {
+	id, err := primitive.ObjectIDFromHex(returnId)
+	if err != nil {
+		return nil, ErrReturnIdIsNotValid
+	}
+
+	var ret models.Return
+	if err = returnCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&ret); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrReturnNotFound
+		}
+		log.Println(err)
+		return nil, err
+	}
+	return &ret, nil
+}
+
+// ListReturns returns the returns matching filter, oldest first
+func ListReturns(ctx context.Context, returnCollection *mongo.Collection, filter bson.M) ([]models.Return, error) {
+	cursor, err := returnCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	returns := make([]models.Return, 0)
+	if err = cursor.All(ctx, &returns); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return returns, nil
+}
@@ -0,0 +1,182 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrSessionNotFound     = errors.New("session not found")
+	ErrCantUpdateSession   = errors.New("cant update session")
+	ErrRefreshTokenReused  = errors.New("refresh token has already been used")
+	ErrSessionIdIsNotValid = errors.New("session id is not valid")
+)
+
+// how stale lastSeenAt may get before a request refreshes it
+const lastSeenResolution = time.Minute
+
+// Retrieves sessions from the database
+func SessionData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return collection
+}
+
+// CreateSession records a new signed in device for userId. The id is chosen by the caller
+// because the tokens naming the session are issued before it is stored.
+func CreateSession(ctx context.Context, sessionCollection *mongo.Collection, sessionId primitive.ObjectID, userId, refreshTokenHash, userAgent, ip string, ttl time.Duration) (*models.Session, error) {
+	now := time.Now()
+	session := models.Session{
+		ID:               sessionId,
+		UserId:           userId,
+		UserAgent:        userAgent,
+		IP:               ip,
+		CreatedAt:        now,
+		LastSeenAt:       now,
+		ExpiresAt:        now.Add(ttl),
+		RefreshTokenHash: refreshTokenHash,
+	}
+	if _, err := sessionCollection.InsertOne(ctx, session); err != nil {
+		log.Println(err)
+		return nil, ErrCantUpdateSession
+	}
+	return &session, nil
+}
+
+// ActiveSession returns the session if it belongs to userId and is neither revoked nor expired
+func ActiveSession(ctx context.Context, sessionCollection *mongo.Collection, sessionId, userId string) (*models.Session, error) {
+	id, err := primitive.ObjectIDFromHex(sessionId)
+	if err != nil {
+		return nil, ErrSessionNotFound
+	}
+
+	filter := bson.M{"_id": id, "userId": userId, "revokedAt": nil, "expiresAt": bson.M{"$gt": time.Now()}}
+	var session models.Session
+	if err = sessionCollection.FindOne(ctx, filter).Decode(&session); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrSessionNotFound
+		}
+		log.Println(err)
+		return nil, err
+	}
+	return &session, nil
+}
+
+// TouchSession updates where and when the session was last used. To keep writes cheap
+// it only does so once lastSeenAt is older than lastSeenResolution.
+func TouchSession(ctx context.Context, sessionCollection *mongo.Collection, session *models.Session, ip string) error {
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < lastSeenResolution && session.IP == ip {
+		return nil
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "lastSeenAt", Value: now}, {Key: "ip", Value: ip}}}}
+	if _, err := sessionCollection.UpdateOne(ctx, bson.M{"_id": session.ID}, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateSession
+	}
+	return nil
+}
+
+// RotateRefreshToken swaps the refresh token hash of the session and extends it. It only
+// succeeds when previousHash is the current one, so a refresh token works exactly once.
+func RotateRefreshToken(ctx context.Context, sessionCollection *mongo.Collection, sessionId primitive.ObjectID, previousHash, nextHash, userAgent, ip string, ttl time.Duration) error {
+	now := time.Now()
+	filter := bson.M{"_id": sessionId, "refreshTokenHash": previousHash, "revokedAt": nil}
+	update := bson.D{{Key: "$set", Value: bson.D{
+		primitive.E{Key: "refreshTokenHash", Value: nextHash},
+		{Key: "lastSeenAt", Value: now},
+		{Key: "expiresAt", Value: now.Add(ttl)},
+		{Key: "userAgent", Value: userAgent},
+		{Key: "ip", Value: ip},
+	}}}
+	result, err := sessionCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateSession
+	}
+	if result.MatchedCount == 0 {
+		return ErrRefreshTokenReused
+	}
+	return nil
+}
+
+// ListSessions returns the active sessions of userId, most recently used first
+func ListSessions(ctx context.Context, sessionCollection *mongo.Collection, userId string) ([]models.Session, error) {
+	filter := bson.M{"userId": userId, "revokedAt": nil, "expiresAt": bson.M{"$gt": time.Now()}}
+	cursor, err := sessionCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"lastSeenAt": -1}))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	sessions := make([]models.Session, 0)
+	if err = cursor.All(ctx, &sessions); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession ends one session of userId
+func RevokeSession(ctx context.Context, sessionCollection *mongo.Collection, sessionId, userId string) error {
+	id, err := primitive.ObjectIDFromHex(sessionId)
+	if err != nil {
+		return ErrSessionIdIsNotValid
+	}
+
+	filter := bson.M{"_id": id, "userId": userId, "revokedAt": nil}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "revokedAt", Value: time.Now()}}}}
+	result, err := sessionCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateSession
+	}
+	if result.MatchedCount == 0 {
+		return ErrSessionNotFound
+	}
+	return nil
+}
+
+// RevokeAllSessions ends every session of userId, signing the user out everywhere
+func RevokeAllSessions(ctx context.Context, sessionCollection *mongo.Collection, userId string) error {
+	filter := bson.M{"userId": userId, "revokedAt": nil}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "revokedAt", Value: time.Now()}}}}
+	if _, err := sessionCollection.UpdateMany(ctx, filter, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateSession
+	}
+	return nil
+}
+
+// RevokeOtherSessions revokes every session of the user except the one making the request
+func RevokeOtherSessions(ctx context.Context, sessionCollection *mongo.Collection, userId, currentSessionId string) error {
+	keep, err := primitive.ObjectIDFromHex(currentSessionId)
+	if err != nil {
+		return ErrSessionIdIsNotValid
+	}
+
+	filter := bson.M{"userId": userId, "revokedAt": nil, "_id": bson.M{"$ne": keep}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "revokedAt", Value: time.Now()}}}}
+	if _, err := sessionCollection.UpdateMany(ctx, filter, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateSession
+	}
+	return nil
+}
+
+// DeleteSessions removes every session of the user, revoked or not
+func DeleteSessions(ctx context.Context, sessionCollection *mongo.Collection, userId string) error {
+	if _, err := sessionCollection.DeleteMany(ctx, bson.M{"userId": userId}); err != nil {
+		log.Println(err)
+		return ErrCantUpdateSession
+	}
+	return nil
+}
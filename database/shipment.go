@@ -0,0 +1,187 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrShipmentNotFound     = errors.New("shipment not found")
+	ErrShipmentIdIsNotValid = errors.New("shipment id is not valid")
+	ErrOrderNotShippable    = errors.New("the order is not waiting to be shipped")
+	ErrShipmentExceedsOrder = errors.New("the shipment holds more items than are left to ship")
+	ErrCantCreateShipment   = errors.New("cant create shipment")
+)
+
+// shippableStatuses are the order statuses that still have items to ship
+var shippableStatuses = bson.A{models.OrderPlaced, models.OrderPartiallyShipped}
+
+func ShipmentData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return collection
+}
+
+// CreateShipment records shipment and counts its items as shipped on the order. The
+// order must still have enough of each line left to ship when the update lands, so two
+// concurrent shipments cannot send the same items. The order then moves to partially
+// shipped or, once every line has shipped in full, to shipped. All of it happens in one
+// transaction with a ShipmentSent event.
+func CreateShipment(ctx context.Context, orderCollection, shipmentCollection, outboxCollection *mongo.Collection, order models.Order, shipment models.Shipment) error {
+	quantities := make(map[primitive.ObjectID]int)
+	for _, line := range order.LineItems {
+		quantities[line.LineId] = line.Quantity
+	}
+
+	shipped := make(map[primitive.ObjectID]int)
+	for _, item := range shipment.Items {
+		if item.Quantity <= 0 {
+			return ErrShipmentExceedsOrder
+		}
+		shipped[item.LineId] += item.Quantity
+	}
+	if len(shipped) == 0 {
+		return ErrShipmentExceedsOrder
+	}
+	conditions := bson.A{}
+	for lineId, quantity := range shipped {
+		total, ok := quantities[lineId]
+		if !ok {
+			return ErrShipmentExceedsOrder
+		}
+		conditions = append(conditions, lineAtMost(lineId, "shippedQuantity", total-quantity))
+	}
+
+	increments, arrayFilters := lineIncrements("shippedQuantity", shipped)
+	filter := bson.M{"_id": order.OrderId, "status": bson.M{"$in": shippableStatuses}, "$and": conditions}
+	opts := options.Update().SetArrayFilters(options.ArrayFilters{Filters: arrayFilters})
+	err := WithEvents(ctx, outboxCollection, func(ctx context.Context) error {
+		result, err := orderCollection.UpdateOne(ctx, filter, bson.M{"$inc": increments}, opts)
+		if err != nil {
+			return err
+		}
+		if result.MatchedCount == 0 {
+			return ErrShipmentExceedsOrder
+		}
+		if _, err = shipmentCollection.InsertOne(ctx, shipment); err != nil {
+			return err
+		}
+		return updateShippingStatus(ctx, orderCollection, order.OrderId)
+	}, models.NewEvent(models.EventShipmentSent, shipment.ShipmentId.Hex(), shipment.UserId))
+	if err != nil && err != ErrShipmentExceedsOrder {
+		log.Println(err)
+		return ErrCantCreateShipment
+	}
+	return err
+}
+
+// updateShippingStatus derives the status of an order from its shipped quantities. It
+// reads them in the same update, so concurrent shipments always settle on the right one.
+func updateShippingStatus(ctx context.Context, orderCollection *mongo.Collection, orderId primitive.ObjectID) error {
+	allShipped := bson.M{"$allElementsTrue": bson.A{bson.M{"$map": bson.M{
+		"input": "$lineItems",
+		"as":    "line",
+		"in":    bson.M{"$gte": bson.A{bson.M{"$ifNull": bson.A{"$$line.shippedQuantity", 0}}, "$$line.quantity"}},
+	}}}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"status": bson.M{"$cond": bson.A{allShipped, models.OrderShipped, models.OrderPartiallyShipped}},
+	}}}}
+	filter := bson.M{"_id": orderId, "status": bson.M{"$in": shippableStatuses}}
+	if _, err := orderCollection.UpdateOne(ctx, filter, update); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
+
+// ListShipments returns the shipments of an order, oldest first
+func ListShipments(ctx context.Context, shipmentCollection *mongo.Collection, orderId primitive.ObjectID) ([]models.Shipment, error) {
+	cursor, err := shipmentCollection.Find(ctx, bson.M{"orderId": orderId}, options.Find().SetSort(bson.M{"createdAt": 1}))
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+
+	shipments := make([]models.Shipment, 0)
+	if err = cursor.All(ctx, &shipments); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return shipments, nil
+}
+
+func FindShipment(ctx context.Context, shipmentCollection *mongo.Collection, shipmentId string) (*models.Shipment, error) {
+	id, err := primitive.ObjectIDFromHex(shipmentId)
+	if err != nil {
+		return nil, ErrShipmentIdIsNotValid
+	}
+
+	var shipment models.Shipment
+	if err = shipmentCollection.FindOne(ctx, bson.M{"_id": id}).Decode(&shipment); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrShipmentNotFound
+		}
+		log.Println(err)
+		return nil, err
+	}
+	return &shipment, nil
+}
+
+// AddShipmentEvent appends a tracking update to a shipment and makes its status the
+// shipment's current one
+func AddShipmentEvent(ctx context.Context, shipmentCollection *mongo.Collection, shipmentId string, event models.ShipmentEvent) (*models.Shipment, error) {
+	id, err := primitive.ObjectIDFromHex(shipmentId)
+	if err != nil {
+		return nil, ErrShipmentIdIsNotValid
+	}
+
+	update := bson.M{
+		"$push": bson.M{"events": event},
+		"$set":  bson.M{"status": event.Status, "updatedAt": time.Now()},
+	}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var shipment models.Shipment
+	if err = shipmentCollection.FindOneAndUpdate(ctx, bson.M{"_id": id}, update, opts).Decode(&shipment); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrShipmentNotFound
+		}
+		log.Println(err)
+		return nil, err
+	}
+	return &shipment, nil
+}
+
+// MarkOrderDelivered moves a shipped order to delivered once none of its shipments is
+// still on its way. It returns the delivered order, or nil while it is not delivered.
+func MarkOrderDelivered(ctx context.Context, orderCollection, shipmentCollection *mongo.Collection, orderId primitive.ObjectID) (*models.Order, error) {
+	pending, err := shipmentCollection.CountDocuments(ctx, bson.M{"orderId": orderId, "status": bson.M{"$ne": models.ShipmentDelivered}})
+	if err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	if pending > 0 {
+		return nil, nil
+	}
+
+	filter := bson.M{"_id": orderId, "status": models.OrderShipped}
+	update := bson.M{"$set": bson.M{"status": models.OrderDelivered, "deliveredAt": time.Now()}}
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After)
+
+	var order models.Order
+	if err = orderCollection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&order); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, nil
+		}
+		log.Println(err)
+		return nil, err
+	}
+	return &order, nil
+}
@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"go-ecommerce/models"
+	"log"
+	"math"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// LoginThrottle describes when failed logins for one kind of key lead to a lockout
+type LoginThrottle struct {
+	// Threshold is the number of failures allowed before the key is locked
+	Threshold int
+	// BaseLockout is the first lockout; each further failure doubles it up to MaxLockout
+	BaseLockout time.Duration
+	MaxLockout  time.Duration
+	// Window after the last failure at which the counter starts over
+	Window time.Duration
+}
+
+// Retrieves login attempt counters from the database
+func LoginAttemptData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return collection
+}
+
+// LoginLockedUntil returns the latest lockout among the given keys, or the zero time
+// when none of them is locked
+func LoginLockedUntil(ctx context.Context, attemptCollection *mongo.Collection, keys ...string) (time.Time, error) {
+	cursor, err := attemptCollection.Find(ctx, bson.M{"_id": bson.M{"$in": keys}, "lockedUntil": bson.M{"$gt": time.Now()}})
+	if err != nil {
+		log.Println(err)
+		return time.Time{}, err
+	}
+
+	var attempts []models.LoginAttempt
+	if err = cursor.All(ctx, &attempts); err != nil {
+		log.Println(err)
+		return time.Time{}, err
+	}
+
+	var lockedUntil time.Time
+	for _, attempt := range attempts {
+		if attempt.LockedUntil != nil && attempt.LockedUntil.After(lockedUntil) {
+			lockedUntil = *attempt.LockedUntil
+		}
+	}
+	return lockedUntil, nil
+}
+
+// RecordLoginFailure counts a failed login for key. When the failure pushes the key over
+// the threshold it is locked and the end of the lockout is returned.
+func RecordLoginFailure(ctx context.Context, attemptCollection *mongo.Collection, key string, throttle LoginThrottle) (*time.Time, error) {
+	now := time.Now()
+	// failures older than the window no longer count
+	stale := bson.M{"$lt": bson.A{bson.M{"$ifNull": bson.A{"$lastFailureAt", now}}, now.Add(-throttle.Window)}}
+	update := mongo.Pipeline{
+		{{Key: "$set", Value: bson.M{
+			"failures":      bson.M{"$cond": bson.A{stale, 1, bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{"$failures", 0}}, 1}}}},
+			"lastFailureAt": now,
+		}}},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	var attempt models.LoginAttempt
+	if err := attemptCollection.FindOneAndUpdate(ctx, bson.M{"_id": key}, update, opts).Decode(&attempt); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	if attempt.Failures < throttle.Threshold {
+		return nil, nil
+	}
+
+	lockout := time.Duration(float64(throttle.BaseLockout) * math.Pow(2, float64(attempt.Failures-throttle.Threshold)))
+	if lockout > throttle.MaxLockout || lockout <= 0 {
+		lockout = throttle.MaxLockout
+	}
+	lockedUntil := now.Add(lockout)
+	if _, err := attemptCollection.UpdateOne(ctx, bson.M{"_id": key}, bson.M{"$set": bson.M{"lockedUntil": lockedUntil}}); err != nil {
+		log.Println(err)
+		return nil, err
+	}
+	return &lockedUntil, nil
+}
+
+// ClearLoginFailures forgets the failures recorded for key after a successful login
+func ClearLoginFailures(ctx context.Context, attemptCollection *mongo.Collection, key string) error {
+	if _, err := attemptCollection.DeleteOne(ctx, bson.M{"_id": key}); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
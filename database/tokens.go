@@ -0,0 +1,127 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrCantCreateToken    = errors.New("cant create token")
+	ErrInvalidActionToken = errors.New("token is invalid or has expired")
+)
+
+// Retrieves single-use action tokens from the database
+func ActionTokenData(client *mongo.Client, collectionName string) *mongo.Collection {
+	var collection *mongo.Collection = client.Database("Ecommerce").Collection(collectionName)
+	return collection
+}
+
+// HashActionToken returns the form an action token is stored and looked up in
+func HashActionToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// CreateActionToken issues a new single-use token for userId and returns its plaintext,
+// which is never stored. Earlier unused tokens for the same purpose are invalidated.
+func CreateActionToken(ctx context.Context, tokenCollection *mongo.Collection, userId, purpose string, ttl time.Duration) (string, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		log.Println(err)
+		return "", ErrCantCreateToken
+	}
+	plaintext := base64.RawURLEncoding.EncodeToString(raw)
+
+	now := time.Now()
+	filter := bson.M{"userId": userId, "purpose": purpose, "usedAt": nil}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "usedAt", Value: now}}}}
+	if _, err := tokenCollection.UpdateMany(ctx, filter, update); err != nil {
+		log.Println(err)
+		return "", ErrCantCreateToken
+	}
+
+	actionToken := models.ActionToken{
+		ID:        primitive.NewObjectID(),
+		UserId:    userId,
+		Purpose:   purpose,
+		TokenHash: HashActionToken(plaintext),
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if _, err := tokenCollection.InsertOne(ctx, actionToken); err != nil {
+		log.Println(err)
+		return "", ErrCantCreateToken
+	}
+	return plaintext, nil
+}
+
+// usableTokenFilter matches the token for purpose while it is unused and unexpired
+func usableTokenFilter(plaintext, purpose string, now time.Time) bson.M {
+	return bson.M{
+		"tokenHash": HashActionToken(plaintext),
+		"purpose":   purpose,
+		"usedAt":    nil,
+		"expiresAt": bson.M{"$gt": now},
+	}
+}
+
+// CheckActionToken reports whether the token could still be consumed, without using it
+func CheckActionToken(ctx context.Context, tokenCollection *mongo.Collection, plaintext, purpose string) error {
+	count, err := tokenCollection.CountDocuments(ctx, usableTokenFilter(plaintext, purpose, time.Now()))
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if count == 0 {
+		return ErrInvalidActionToken
+	}
+	return nil
+}
+
+// ConsumeActionToken marks the token as used and returns it. A token can be consumed
+// exactly once and only before it expires.
+func ConsumeActionToken(ctx context.Context, tokenCollection *mongo.Collection, plaintext, purpose string) (*models.ActionToken, error) {
+	now := time.Now()
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "usedAt", Value: now}}}}
+
+	var actionToken models.ActionToken
+	if err := tokenCollection.FindOneAndUpdate(ctx, usableTokenFilter(plaintext, purpose, now), update).Decode(&actionToken); err != nil {
+		if err != mongo.ErrNoDocuments {
+			log.Println(err)
+		}
+		return nil, ErrInvalidActionToken
+	}
+	return &actionToken, nil
+}
+
+// CountRecentActionTokens returns how many tokens for purpose were issued to userId
+// since the given time, for rate limiting.
+func CountRecentActionTokens(ctx context.Context, tokenCollection *mongo.Collection, userId, purpose string, since time.Time) (int64, error) {
+	filter := bson.M{"userId": userId, "purpose": purpose, "createdAt": bson.M{"$gte": since}}
+	count, err := tokenCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Println(err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// DeleteActionTokens removes every action token issued to the user
+func DeleteActionTokens(ctx context.Context, tokenCollection *mongo.Collection, userId string) error {
+	if _, err := tokenCollection.DeleteMany(ctx, bson.M{"userId": userId}); err != nil {
+		log.Println(err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,237 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"go-ecommerce/models"
+	"log"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	ErrUserNotFound       = errors.New("user not found")
+	ErrAdminAlreadyExists = errors.New("an admin already exists")
+	ErrInvalidRole        = errors.New("invalid role")
+	ErrEmailInUse         = errors.New("this email address is already in use")
+	ErrNoPendingEmail     = errors.New("no email change is pending")
+)
+
+// SetUserRole grants role to the user with the given id
+func SetUserRole(ctx context.Context, userCollection *mongo.Collection, userId, role string) error {
+	if !models.IsValidRole(role) {
+		return ErrInvalidRole
+	}
+
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "role", Value: role}}}}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// BootstrapAdmin promotes the user with the given email to admin. It only succeeds while
+// no admin exists, so it can be used to create the first admin and nothing more.
+func BootstrapAdmin(ctx context.Context, userCollection *mongo.Collection, email string) error {
+	count, err := userCollection.CountDocuments(ctx, bson.M{"role": models.RoleAdmin})
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+	if count > 0 {
+		return ErrAdminAlreadyExists
+	}
+
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "role", Value: models.RoleAdmin}}}}
+	result, err := userCollection.UpdateOne(ctx, bson.M{"email": email}, update)
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// UpdatePassword replaces the stored password hash of the user
+func UpdatePassword(ctx context.Context, userCollection *mongo.Collection, userId, passwordHash string) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "password", Value: passwordHash}, {Key: "updatedat", Value: time.Now()}}}}
+	if _, err = userCollection.UpdateOne(ctx, filter, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	return nil
+}
+
+// MarkEmailVerified records that the user proved ownership of their email address
+func MarkEmailVerified(ctx context.Context, userCollection *mongo.Collection, userId string) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "emailVerified", Value: true}, {Key: "emailVerifiedAt", Value: time.Now()}}}}
+	if _, err = userCollection.UpdateOne(ctx, filter, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	return nil
+}
+
+// IsEmailVerified reports whether the user has verified their email address
+func IsEmailVerified(ctx context.Context, userCollection *mongo.Collection, userId string) (bool, error) {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return false, ErrUserIdIsNotValid
+	}
+
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"emailVerified": 1})
+	if err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}, opts).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return false, ErrUserNotFound
+		}
+		log.Println(err)
+		return false, err
+	}
+	return user.EmailVerified, nil
+}
+
+// FindUserById loads the user with the given hex id
+func FindUserById(ctx context.Context, userCollection *mongo.Collection, userId string) (*models.User, error) {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return nil, ErrUserIdIsNotValid
+	}
+
+	var user models.User
+	if err = userCollection.FindOne(ctx, bson.D{primitive.E{Key: "_id", Value: id}}).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, ErrUserNotFound
+		}
+		log.Println(err)
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UpdateProfile sets whichever of the given fields are not nil
+func UpdateProfile(ctx context.Context, userCollection *mongo.Collection, userId string, firstName, lastName, phone, locale *string) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	fields := bson.D{primitive.E{Key: "updatedat", Value: time.Now()}}
+	if firstName != nil {
+		fields = append(fields, primitive.E{Key: "firstname", Value: *firstName})
+	}
+	if lastName != nil {
+		fields = append(fields, primitive.E{Key: "lastname", Value: *lastName})
+	}
+	if phone != nil {
+		fields = append(fields, primitive.E{Key: "phone", Value: *phone})
+	}
+	if locale != nil {
+		fields = append(fields, primitive.E{Key: "locale", Value: *locale})
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+	result, err := userCollection.UpdateOne(ctx, filter, bson.D{{Key: "$set", Value: fields}})
+	if err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetPendingEmail stores the address the user asked to change to until they verify it
+func SetPendingEmail(ctx context.Context, userCollection *mongo.Collection, userId, email string) error {
+	id, err := primitive.ObjectIDFromHex(userId)
+	if err != nil {
+		log.Println(err)
+		return ErrUserIdIsNotValid
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: id}}
+	update := bson.D{{Key: "$set", Value: bson.D{primitive.E{Key: "pendingEmail", Value: email}}}}
+	if _, err = userCollection.UpdateOne(ctx, filter, update); err != nil {
+		log.Println(err)
+		return ErrCantUpdateUser
+	}
+	return nil
+}
+
+// ConfirmPendingEmail makes the pending address the user's verified email and returns it
+func ConfirmPendingEmail(ctx context.Context, userCollection *mongo.Collection, userId string) (string, error) {
+	user, err := FindUserById(ctx, userCollection, userId)
+	if err != nil {
+		return "", err
+	}
+	if user.PendingEmail == nil {
+		return "", ErrNoPendingEmail
+	}
+	email := *user.PendingEmail
+
+	// another account may have claimed the address since the change was requested
+	count, err := userCollection.CountDocuments(ctx, bson.M{"email": email})
+	if err != nil {
+		log.Println(err)
+		return "", err
+	}
+	if count > 0 {
+		return "", ErrEmailInUse
+	}
+
+	filter := bson.D{primitive.E{Key: "_id", Value: user.ID}, {Key: "pendingEmail", Value: email}}
+	update := bson.D{
+		{Key: "$set", Value: bson.D{
+			primitive.E{Key: "email", Value: email},
+			{Key: "emailVerified", Value: true},
+			{Key: "emailVerifiedAt", Value: time.Now()},
+			{Key: "updatedat", Value: time.Now()},
+		}},
+		{Key: "$unset", Value: bson.D{primitive.E{Key: "pendingEmail", Value: ""}}},
+	}
+	result, err := userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		log.Println(err)
+		return "", ErrCantUpdateUser
+	}
+	if result.MatchedCount == 0 {
+		return "", ErrNoPendingEmail
+	}
+	return email, nil
+}
@@ -0,0 +1,71 @@
+package dto
+
+import "go-ecommerce/models"
+
+// AddressRequest is the body for creating or replacing an address book entry. Which
+// fields are required depends on the country and is checked by the postal package.
+type AddressRequest struct {
+	Label           string `json:"label" validate:"max=40"`
+	House           string `json:"house" validate:"max=100"`
+	Street          string `json:"street" validate:"max=200"`
+	City            string `json:"city" validate:"max=100"`
+	Region          string `json:"region" validate:"max=100"`
+	PinCode         string `json:"pinCode" validate:"max=20"`
+	Country         string `json:"country" validate:"required,len=2"`
+	DefaultShipping bool   `json:"defaultShipping"`
+	DefaultBilling  bool   `json:"defaultBilling"`
+}
+
+// NewAddress maps an address request to an address book entry
+func NewAddress(req AddressRequest) models.Address {
+	return models.Address{
+		Label:           &req.Label,
+		House:           &req.House,
+		Street:          &req.Street,
+		City:            &req.City,
+		Region:          &req.Region,
+		PinCode:         &req.PinCode,
+		Country:         &req.Country,
+		DefaultShipping: req.DefaultShipping,
+		DefaultBilling:  req.DefaultBilling,
+	}
+}
+
+// AddressResponse is the view of an address book entry
+type AddressResponse struct {
+	AddressId       string `json:"addressId"`
+	Label           string `json:"label"`
+	House           string `json:"house"`
+	Street          string `json:"street"`
+	City            string `json:"city"`
+	Region          string `json:"region,omitempty"`
+	PinCode         string `json:"pinCode"`
+	Country         string `json:"country"`
+	DefaultShipping bool   `json:"defaultShipping"`
+	DefaultBilling  bool   `json:"defaultBilling"`
+}
+
+// NewAddressResponse maps an address book entry to its API view
+func NewAddressResponse(address models.Address) AddressResponse {
+	return AddressResponse{
+		AddressId:       address.AddressId.Hex(),
+		Label:           deref(address.Label),
+		House:           deref(address.House),
+		Street:          deref(address.Street),
+		City:            deref(address.City),
+		Region:          deref(address.Region),
+		PinCode:         deref(address.PinCode),
+		Country:         deref(address.Country),
+		DefaultShipping: address.DefaultShipping,
+		DefaultBilling:  address.DefaultBilling,
+	}
+}
+
+// NewAddressResponses maps a whole address book
+func NewAddressResponses(addresses []models.Address) []AddressResponse {
+	responses := make([]AddressResponse, 0, len(addresses))
+	for _, address := range addresses {
+		responses = append(responses, NewAddressResponse(address))
+	}
+	return responses
+}
@@ -0,0 +1,74 @@
+package dto
+
+import (
+	"go-ecommerce/models"
+	"time"
+)
+
+// RefundRequest is the body for refunding an order. With lines, the amount is split
+// across them and may be left out; without either, whatever is left is refunded.
+type RefundRequest struct {
+	Amount int                 `json:"amount" validate:"min=0"`
+	Lines  []RefundLineRequest `json:"lines" validate:"omitempty,dive"`
+	Reason string              `json:"reason" validate:"required,max=500"`
+}
+
+// RefundLineRequest is the part of a refund for one order line
+type RefundLineRequest struct {
+	LineId string `json:"lineId" validate:"required"`
+	Amount int    `json:"amount" validate:"required,min=1"`
+}
+
+// RefundLineResponse is the view of the part of a refund for one order line
+type RefundLineResponse struct {
+	LineId string `json:"lineId"`
+	Amount int    `json:"amount"`
+}
+
+// LedgerEntryResponse is the view of a ledger entry
+type LedgerEntryResponse struct {
+	EntryId   string               `json:"entryId"`
+	OrderId   string               `json:"orderId"`
+	Kind      string               `json:"kind"`
+	Status    string               `json:"status"`
+	Amount    int                  `json:"amount"`
+	Currency  string               `json:"currency"`
+	Method    string               `json:"method,omitempty"`
+	Source    string               `json:"source,omitempty"`
+	Reason    string               `json:"reason,omitempty"`
+	Lines     []RefundLineResponse `json:"lines,omitempty"`
+	ReturnId  string               `json:"returnId,omitempty"`
+	CreatedAt time.Time            `json:"createdAt"`
+}
+
+// NewLedgerEntryResponse maps a ledger entry to its API view
+func NewLedgerEntryResponse(entry models.LedgerEntry) LedgerEntryResponse {
+	response := LedgerEntryResponse{
+		EntryId:   entry.EntryId.Hex(),
+		OrderId:   entry.OrderId.Hex(),
+		Kind:      entry.Kind,
+		Status:    entry.Status,
+		Amount:    entry.Amount,
+		Currency:  entry.Currency,
+		Method:    entry.Method,
+		Source:    entry.Source,
+		Reason:    entry.Reason,
+		CreatedAt: entry.CreatedAt,
+	}
+	for _, line := range entry.Lines {
+		response.Lines = append(response.Lines, RefundLineResponse{LineId: line.LineId.Hex(), Amount: line.Amount})
+	}
+	if entry.ReturnId != nil {
+		response.ReturnId = entry.ReturnId.Hex()
+	}
+	return response
+}
+
+// NewLedgerEntryResponses maps a list of ledger entries
+func NewLedgerEntryResponses(entries []models.LedgerEntry) []LedgerEntryResponse {
+	responses := make([]LedgerEntryResponse, 0, len(entries))
+	for _, entry := range entries {
+		responses = append(responses, NewLedgerEntryResponse(entry))
+	}
+	return responses
+}
@@ -0,0 +1,143 @@
+package dto
+
+import (
+	"go-ecommerce/models"
+	"time"
+)
+
+// Payment methods a checkout can choose
+const (
+	PaymentCOD     = "cod"
+	PaymentDigital = "digital"
+)
+
+// CheckoutRequest is the body of POST /orders. Without destinations the whole cart
+// ships to the default shipping address.
+type CheckoutRequest struct {
+	PaymentMethod string               `json:"paymentMethod" validate:"omitempty,oneof=cod digital"`
+	PaymentToken  string               `json:"paymentToken" validate:"required_if=PaymentMethod digital"`
+	Destinations  []DestinationRequest `json:"destinations" validate:"omitempty,dive"`
+}
+
+// DestinationRequest assigns cart items to one address of the address book
+type DestinationRequest struct {
+	AddressId string        `json:"addressId" validate:"required"`
+	Items     []ItemRequest `json:"items" validate:"required,min=1,dive"`
+}
+
+// ItemRequest is a quantity of one product in the cart
+type ItemRequest struct {
+	ProductId string `json:"productId" validate:"required"`
+	Quantity  int    `json:"quantity" validate:"required,min=1"`
+}
+
+// CancelOrderRequest is the body of POST /orders/:orderId/cancel
+type CancelOrderRequest struct {
+	Reason string `json:"reason" validate:"required,max=500"`
+}
+
+// LineItemResponse is the view of an order line
+type LineItemResponse struct {
+	LineId           string `json:"lineId"`
+	ProductId        string `json:"productId"`
+	ProductName      string `json:"productName"`
+	Price            int    `json:"price"`
+	Quantity         int    `json:"quantity"`
+	ShippedQuantity  int    `json:"shippedQuantity"`
+	ReturnedQuantity int    `json:"returnedQuantity"`
+	RefundedAmount   int    `json:"refundedAmount"`
+	DestinationId    string `json:"destinationId"`
+}
+
+// DestinationResponse is the view of one destination of an order
+type DestinationResponse struct {
+	DestinationId string               `json:"destinationId"`
+	Address       AddressResponse      `json:"address"`
+	ShippingQuote models.ShippingQuote `json:"shippingQuote"`
+}
+
+// OrderResponse is the view of an order
+type OrderResponse struct {
+	OrderId       string                `json:"orderId"`
+	Status        string                `json:"status"`
+	OrderedAt     time.Time             `json:"orderedAt"`
+	LineItems     []LineItemResponse    `json:"lineItems"`
+	Destinations  []DestinationResponse `json:"destinations"`
+	ItemsTotal    int                   `json:"itemsTotal"`
+	ShippingTotal int                   `json:"shippingTotal"`
+	TotalPrice    int                   `json:"totalPrice"`
+	RefundedTotal int                   `json:"refundedTotal"`
+	PaymentMethod string                `json:"paymentMethod"`
+	PaymentStatus string                `json:"paymentStatus,omitempty"`
+	PaidAt        *time.Time            `json:"paidAt,omitempty"`
+	DeliveredAt   *time.Time            `json:"deliveredAt,omitempty"`
+	InvoiceNumber string                `json:"invoiceNumber,omitempty"`
+	Cancellation  *CancellationResponse `json:"cancellation,omitempty"`
+}
+
+// CancellationResponse is the view of why and when an order was cancelled
+type CancellationResponse struct {
+	Reason      string    `json:"reason"`
+	CancelledAt time.Time `json:"cancelledAt"`
+}
+
+// NewOrderResponse maps an order to its API view
+func NewOrderResponse(order models.Order) OrderResponse {
+	lines := make([]LineItemResponse, 0, len(order.LineItems))
+	for _, line := range order.LineItems {
+		lines = append(lines, LineItemResponse{
+			LineId:           line.LineId.Hex(),
+			ProductId:        line.ProductId.Hex(),
+			ProductName:      deref(line.ProductName),
+			Price:            line.Price,
+			Quantity:         line.Quantity,
+			ShippedQuantity:  line.ShippedQuantity,
+			ReturnedQuantity: line.ReturnedQuantity,
+			RefundedAmount:   line.RefundedAmount,
+			DestinationId:    line.DestinationId.Hex(),
+		})
+	}
+	destinations := make([]DestinationResponse, 0, len(order.Destinations))
+	for _, destination := range order.Destinations {
+		destinations = append(destinations, DestinationResponse{
+			DestinationId: destination.DestinationId.Hex(),
+			Address:       NewAddressResponse(destination.Address),
+			ShippingQuote: destination.Quote,
+		})
+	}
+
+	response := OrderResponse{
+		OrderId:       order.OrderId.Hex(),
+		Status:        order.Status,
+		OrderedAt:     order.OrderedAt,
+		LineItems:     lines,
+		Destinations:  destinations,
+		ItemsTotal:    order.ItemsTotal,
+		ShippingTotal: order.ShippingTotal,
+		TotalPrice:    order.Price,
+		RefundedTotal: order.RefundedAmount,
+		PaymentMethod: PaymentCOD,
+		PaidAt:        order.PaidAt,
+		DeliveredAt:   order.DeliveredAt,
+		InvoiceNumber: order.InvoiceNumber,
+	}
+	if order.PaymentMethod.Digital {
+		response.PaymentMethod = PaymentDigital
+	}
+	if order.Charge != nil {
+		response.PaymentStatus = order.Charge.Status
+	}
+	if order.Cancellation != nil {
+		response.Cancellation = &CancellationResponse{Reason: order.Cancellation.Reason, CancelledAt: order.Cancellation.CancelledAt}
+	}
+	return response
+}
+
+// NewOrderResponses maps a list of orders
+func NewOrderResponses(orders []models.Order) []OrderResponse {
+	responses := make([]OrderResponse, 0, len(orders))
+	for _, order := range orders {
+		responses = append(responses, NewOrderResponse(order))
+	}
+	return responses
+}
@@ -0,0 +1,83 @@
+package dto
+
+import (
+	"go-ecommerce/models"
+	"time"
+)
+
+// ReturnRequest is the body of POST /orders/:orderId/returns
+type ReturnRequest struct {
+	Items []ReturnItemRequest `json:"items" validate:"required,min=1,dive"`
+	Note  string              `json:"note" validate:"max=1000"`
+}
+
+// ReturnItemRequest is a quantity of one order line to send back and why
+type ReturnItemRequest struct {
+	LineId   string `json:"lineId" validate:"required"`
+	Quantity int    `json:"quantity" validate:"required,min=1"`
+	Reason   string `json:"reason" validate:"required,max=500"`
+}
+
+// ReviewReturnRequest is the body for approving or rejecting a return
+type ReviewReturnRequest struct {
+	Note string `json:"note" validate:"max=1000"`
+}
+
+// ReceiveReturnRequest is the body for receiving returned goods
+type ReceiveReturnRequest struct {
+	Restock bool `json:"restock"`
+}
+
+// ReturnItemResponse is the view of one line of a return
+type ReturnItemResponse struct {
+	LineId   string `json:"lineId"`
+	Quantity int    `json:"quantity"`
+	Reason   string `json:"reason"`
+}
+
+// ReturnResponse is the view of a return
+type ReturnResponse struct {
+	ReturnId     string               `json:"returnId"`
+	OrderId      string               `json:"orderId"`
+	Items        []ReturnItemResponse `json:"items"`
+	Note         string               `json:"note,omitempty"`
+	Status       string               `json:"status"`
+	ReviewNote   string               `json:"reviewNote,omitempty"`
+	Restocked    bool                 `json:"restocked"`
+	RefundAmount int                  `json:"refundAmount"`
+	CreatedAt    time.Time            `json:"createdAt"`
+	UpdatedAt    time.Time            `json:"updatedAt"`
+	ReceivedAt   *time.Time           `json:"receivedAt,omitempty"`
+	CompletedAt  *time.Time           `json:"completedAt,omitempty"`
+}
+
+// NewReturnResponse maps a return to its API view
+func NewReturnResponse(ret models.Return) ReturnResponse {
+	items := make([]ReturnItemResponse, 0, len(ret.Items))
+	for _, item := range ret.Items {
+		items = append(items, ReturnItemResponse{LineId: item.LineId.Hex(), Quantity: item.Quantity, Reason: item.Reason})
+	}
+	return ReturnResponse{
+		ReturnId:     ret.ReturnId.Hex(),
+		OrderId:      ret.OrderId.Hex(),
+		Items:        items,
+		Note:         ret.Note,
+		Status:       ret.Status,
+		ReviewNote:   ret.ReviewNote,
+		Restocked:    ret.Restocked,
+		RefundAmount: ret.RefundAmount,
+		CreatedAt:    ret.CreatedAt,
+		UpdatedAt:    ret.UpdatedAt,
+		ReceivedAt:   ret.ReceivedAt,
+		CompletedAt:  ret.CompletedAt,
+	}
+}
+
+// NewReturnResponses maps a list of returns
+func NewReturnResponses(returns []models.Return) []ReturnResponse {
+	responses := make([]ReturnResponse, 0, len(returns))
+	for _, ret := range returns {
+		responses = append(responses, NewReturnResponse(ret))
+	}
+	return responses
+}
@@ -0,0 +1,34 @@
+package dto
+
+import (
+	"go-ecommerce/models"
+	"time"
+)
+
+// SessionResponse is one signed in device as listed by GET /users/sessions
+type SessionResponse struct {
+	Id         string    `json:"id"`
+	UserAgent  string    `json:"userAgent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Current    bool      `json:"current"`
+}
+
+// NewSessionResponses maps sessions to their API view, flagging the one with id currentId
+func NewSessionResponses(sessions []models.Session, currentId string) []SessionResponse {
+	responses := make([]SessionResponse, 0, len(sessions))
+	for _, session := range sessions {
+		responses = append(responses, SessionResponse{
+			Id:         session.ID.Hex(),
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID.Hex() == currentId,
+		})
+	}
+	return responses
+}
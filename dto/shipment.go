@@ -0,0 +1,99 @@
+package dto
+
+import (
+	"go-ecommerce/models"
+	"time"
+)
+
+// ShipmentRequest is the body for recording a shipment of an order. Without items,
+// everything still left to ship to the destination is in it.
+type ShipmentRequest struct {
+	DestinationId  string                `json:"destinationId" validate:"required"`
+	Carrier        string                `json:"carrier" validate:"max=100"`
+	TrackingNumber string                `json:"trackingNumber" validate:"required,max=100"`
+	TrackingURL    string                `json:"trackingUrl" validate:"omitempty,url,max=500"`
+	Items          []ShipmentItemRequest `json:"items" validate:"omitempty,dive"`
+}
+
+// ShipmentItemRequest is a quantity of one order line
+type ShipmentItemRequest struct {
+	LineId   string `json:"lineId" validate:"required"`
+	Quantity int    `json:"quantity" validate:"required,min=1"`
+}
+
+// ShipmentEventRequest is the body for adding a tracking update to a shipment
+type ShipmentEventRequest struct {
+	Status      string     `json:"status" validate:"required,oneof=shipped in_transit out_for_delivery delivered exception"`
+	Description string     `json:"description" validate:"max=500"`
+	Location    string     `json:"location" validate:"max=200"`
+	OccurredAt  *time.Time `json:"occurredAt"`
+}
+
+// NewShipmentEvent maps a tracking update request, dating it now unless it says when
+func NewShipmentEvent(req ShipmentEventRequest) models.ShipmentEvent {
+	event := models.ShipmentEvent{
+		Status:      req.Status,
+		Description: req.Description,
+		Location:    req.Location,
+		OccurredAt:  time.Now(),
+	}
+	if req.OccurredAt != nil {
+		event.OccurredAt = *req.OccurredAt
+	}
+	return event
+}
+
+// ShipmentItemResponse is the view of a quantity of one order line in a shipment
+type ShipmentItemResponse struct {
+	LineId   string `json:"lineId"`
+	Quantity int    `json:"quantity"`
+}
+
+// ShipmentResponse is the view of a shipment and its tracking history
+type ShipmentResponse struct {
+	ShipmentId     string                 `json:"shipmentId"`
+	OrderId        string                 `json:"orderId"`
+	DestinationId  string                 `json:"destinationId"`
+	Carrier        string                 `json:"carrier"`
+	TrackingNumber string                 `json:"trackingNumber"`
+	TrackingURL    string                 `json:"trackingUrl,omitempty"`
+	Items          []ShipmentItemResponse `json:"items"`
+	Status         string                 `json:"status"`
+	Events         []models.ShipmentEvent `json:"events"`
+	CreatedAt      time.Time              `json:"createdAt"`
+	UpdatedAt      time.Time              `json:"updatedAt"`
+}
+
+// NewShipmentResponse maps a shipment to its API view
+func NewShipmentResponse(shipment models.Shipment) ShipmentResponse {
+	items := make([]ShipmentItemResponse, 0, len(shipment.Items))
+	for _, item := range shipment.Items {
+		items = append(items, ShipmentItemResponse{LineId: item.LineId.Hex(), Quantity: item.Quantity})
+	}
+	events := shipment.Events
+	if events == nil {
+		events = make([]models.ShipmentEvent, 0)
+	}
+	return ShipmentResponse{
+		ShipmentId:     shipment.ShipmentId.Hex(),
+		OrderId:        shipment.OrderId.Hex(),
+		DestinationId:  shipment.DestinationId.Hex(),
+		Carrier:        shipment.Carrier,
+		TrackingNumber: shipment.TrackingNumber,
+		TrackingURL:    shipment.TrackingURL,
+		Items:          items,
+		Status:         shipment.Status,
+		Events:         events,
+		CreatedAt:      shipment.CreatedAt,
+		UpdatedAt:      shipment.UpdatedAt,
+	}
+}
+
+// NewShipmentResponses maps a list of shipments
+func NewShipmentResponses(shipments []models.Shipment) []ShipmentResponse {
+	responses := make([]ShipmentResponse, 0, len(shipments))
+	for _, shipment := range shipments {
+		responses = append(responses, NewShipmentResponse(shipment))
+	}
+	return responses
+}
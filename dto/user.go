@@ -0,0 +1,103 @@
+// Package dto holds the request and response bodies of the API and the mapping between
+// them and the database models. Responses are built by copying fields one by one, so a
+// field added to a model is only exposed once it is added here as well.
+package dto
+
+import (
+	"go-ecommerce/models"
+	"time"
+)
+
+// SignupRequest is the body of POST /users/signup
+type SignupRequest struct {
+	FirstName string `json:"firstName" validate:"required,min=2,max=30"`
+	LastName  string `json:"lastName" validate:"required,min=2,max=30"`
+	Email     string `json:"email" validate:"required,email"`
+	Password  string `json:"password" validate:"required"`
+	Phone     string `json:"phone" validate:"required"`
+}
+
+// LoginRequest is the body of POST /users/login
+type LoginRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+// NewUser maps a signup request to a user document. Everything the request does not
+// carry, such as the password hash, ids and role, is left for the caller to set.
+func NewUser(req SignupRequest) models.User {
+	return models.User{
+		FirstName: &req.FirstName,
+		LastName:  &req.LastName,
+		Email:     &req.Email,
+		Phone:     &req.Phone,
+	}
+}
+
+// IdentityResponse is a linked external account
+type IdentityResponse struct {
+	Provider string    `json:"provider"`
+	Email    string    `json:"email"`
+	LinkedAt time.Time `json:"linkedAt"`
+}
+
+// UserResponse is the view of a user returned by the API
+type UserResponse struct {
+	UserId        string             `json:"userId"`
+	FirstName     string             `json:"firstName"`
+	LastName      string             `json:"lastName"`
+	Email         string             `json:"email"`
+	PendingEmail  string             `json:"pendingEmail,omitempty"`
+	EmailVerified bool               `json:"emailVerified"`
+	Phone         string             `json:"phone"`
+	Locale        string             `json:"locale,omitempty"`
+	Role          string             `json:"role"`
+	TOTPEnabled   bool               `json:"totpEnabled"`
+	HasPassword   bool               `json:"hasPassword"`
+	Identities    []IdentityResponse `json:"identities"`
+	StoreCredit   int                `json:"storeCredit"`
+	DeletionDueAt *time.Time         `json:"deletionDueAt,omitempty"`
+	CreatedAt     time.Time          `json:"createdAt"`
+	UpdatedAt     time.Time          `json:"updatedAt"`
+}
+
+// NewUserResponse maps a user document to its API view
+func NewUserResponse(user models.User) UserResponse {
+	identities := make([]IdentityResponse, 0, len(user.Identities))
+	for _, identity := range user.Identities {
+		identities = append(identities, IdentityResponse{Provider: identity.Provider, Email: identity.Email, LinkedAt: identity.LinkedAt})
+	}
+	return UserResponse{
+		UserId:        user.UserId,
+		FirstName:     deref(user.FirstName),
+		LastName:      deref(user.LastName),
+		Email:         deref(user.Email),
+		PendingEmail:  deref(user.PendingEmail),
+		EmailVerified: user.EmailVerified,
+		Phone:         deref(user.Phone),
+		Locale:        user.Locale,
+		Role:          user.Role,
+		TOTPEnabled:   user.TOTPEnabled,
+		HasPassword:   user.Password != nil,
+		Identities:    identities,
+		StoreCredit:   user.StoreCredit,
+		DeletionDueAt: user.DeletionDueAt,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+	}
+}
+
+// LoginResponse is returned by every endpoint that completes a login. The tokens are
+// left out when they were set as cookies instead.
+type LoginResponse struct {
+	User         UserResponse `json:"user"`
+	Token        string       `json:"token,omitempty"`
+	RefreshToken string       `json:"refreshToken,omitempty"`
+}
+
+func deref(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return *value
+}
@@ -0,0 +1,141 @@
+// Package events relays the domain events of the outbox to in-process subscribers.
+// Delivery is at least once: a subscriber sees an event again if it failed or the
+// process stopped before the event was marked handled, so subscribers must tolerate
+// duplicates.
+package events
+
+import (
+	"context"
+	"fmt"
+	"go-ecommerce/database"
+	"go-ecommerce/models"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	// lease is how long a claimed event is hidden from other relays, and so the longest
+	// a subscriber may take
+	lease = time.Minute
+	// maxBackoff caps the pause before a failed event is tried again
+	maxBackoff = time.Hour
+)
+
+// Handler handles one event
+type Handler func(ctx context.Context, event models.Event) error
+
+type subscriber struct {
+	name    string
+	handler Handler
+}
+
+var (
+	mu          sync.RWMutex
+	subscribers = make(map[string][]subscriber)
+)
+
+// Subscribe has handler called for every event of eventType. name identifies the
+// subscriber in the outbox, which records who handled an event, so it must be unique
+// and must not change between releases.
+func Subscribe(eventType, name string, handler Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	for _, existing := range subscribers[eventType] {
+		if existing.name == name {
+			log.Fatalf("subscriber %s registered twice for %s", name, eventType)
+		}
+	}
+	subscribers[eventType] = append(subscribers[eventType], subscriber{name: name, handler: handler})
+}
+
+func subscribersOf(eventType string) []subscriber {
+	mu.RLock()
+	defer mu.RUnlock()
+	return subscribers[eventType]
+}
+
+// StartRelay dispatches due events from the outbox, checking for new ones every
+// interval. Any number of processes may run a relay.
+func StartRelay(outboxCollection *mongo.Collection, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for ; ; <-ticker.C {
+			for {
+				ctx, cancel := context.WithTimeout(context.Background(), lease)
+				event, err := database.ClaimEvent(ctx, outboxCollection, lease)
+				if err != nil {
+					log.Println("Error claiming outbox event: ", err)
+				}
+				if event != nil {
+					dispatch(ctx, outboxCollection, *event)
+				}
+				cancel()
+				if event == nil {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// dispatch hands the event to every subscriber that has not handled it yet. If any of
+// them fails, the event is tried again later with a growing pause.
+func dispatch(ctx context.Context, outboxCollection *mongo.Collection, event models.Event) {
+	handled := make(map[string]bool, len(event.HandledBy))
+	for _, name := range event.HandledBy {
+		handled[name] = true
+	}
+
+	var failure error
+	for _, sub := range subscribersOf(event.Type) {
+		if handled[sub.name] {
+			continue
+		}
+		if err := handle(ctx, sub, event); err != nil {
+			log.Printf("Error handling %s event %s in %s: %v", event.Type, event.EventId.Hex(), sub.name, err)
+			failure = fmt.Errorf("%s: %w", sub.name, err)
+			continue
+		}
+		if err := database.MarkEventHandled(ctx, outboxCollection, event.EventId, sub.name); err != nil {
+			// it is handled again on the next attempt
+			failure = err
+		}
+	}
+
+	if failure == nil {
+		if err := database.MarkEventDispatched(ctx, outboxCollection, event.EventId); err != nil {
+			log.Println("Error marking outbox event dispatched: ", err)
+		}
+		return
+	}
+	if err := database.RetryEvent(ctx, outboxCollection, event.EventId, time.Now().Add(backoff(event.Attempts)), failure.Error()); err != nil {
+		log.Println("Error rescheduling outbox event: ", err)
+	}
+}
+
+// handle runs one subscriber, turning a panic into an error so it cannot stop the relay
+func handle(ctx context.Context, sub subscriber, event models.Event) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("panic: %v", recovered)
+		}
+	}()
+	return sub.handler(ctx, event)
+}
+
+// backoff is the pause after the given number of failed attempts: 10s, 20s, 40s and so
+// on up to maxBackoff
+func backoff(attempts int) time.Duration {
+	pause := 10 * time.Second
+	for i := 1; i < attempts && pause < maxBackoff; i++ {
+		pause *= 2
+	}
+	if pause > maxBackoff {
+		return maxBackoff
+	}
+	return pause
+}
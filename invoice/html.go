@@ -0,0 +1,30 @@
+package invoice
+
+import (
+	"embed"
+	"go-ecommerce/models"
+	"html/template"
+	"io"
+)
+
+//go:embed templates/invoice.html
+var templates embed.FS
+
+var htmlTemplate = template.Must(template.New("invoice.html").Funcs(template.FuncMap{
+	"addressLines": addressLines,
+	"rate":         FormatRate,
+	// money is replaced per invoice with its currency
+	"money": func(int) string { return "" },
+}).ParseFS(templates, "templates/invoice.html"))
+
+// HTML renders the invoice as a standalone HTML page
+func HTML(w io.Writer, invoice models.Invoice) error {
+	page, err := htmlTemplate.Clone()
+	if err != nil {
+		return err
+	}
+	page.Funcs(template.FuncMap{
+		"money": func(amount int) string { return FormatAmount(amount, invoice.Currency) },
+	})
+	return page.Execute(w, invoice)
+}
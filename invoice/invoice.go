@@ -0,0 +1,147 @@
+// Package invoice builds the invoices of paid orders and renders them as HTML and PDF
+package invoice
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"go-ecommerce/models"
+	"go-ecommerce/payment"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+//go:embed taxrates.json
+var taxRatesJSON []byte
+
+// taxRates are the sales tax or VAT rates by country, in basis points
+var taxRates = loadTaxRates()
+
+func loadTaxRates() map[string]int {
+	rates := make(map[string]int)
+	if err := json.Unmarshal(taxRatesJSON, &rates); err != nil {
+		log.Fatal(err)
+	}
+	return rates
+}
+
+// TaxRate returns the rate in basis points that applies to sales billed to country.
+// Countries without a rate are not taxed.
+func TaxRate(country string) int {
+	return taxRates[strings.ToUpper(country)]
+}
+
+// SellerFromEnv reads the business details printed on invoices from STORE_NAME,
+// STORE_ADDRESS (lines separated by "\n" or ";") and STORE_TAX_ID
+func SellerFromEnv() models.Seller {
+	seller := models.Seller{
+		Name:    os.Getenv("STORE_NAME"),
+		Address: strings.ReplaceAll(os.Getenv("STORE_ADDRESS"), ";", "\n"),
+		TaxId:   os.Getenv("STORE_TAX_ID"),
+	}
+	if seller.Name == "" {
+		seller.Name = "go-ecommerce"
+	}
+	return seller
+}
+
+// NewDraft builds the invoice for a paid order, billed to billing. It is numbered when
+// it is issued. Prices include tax, so the tax is worked out of the total.
+func NewDraft(order models.Order, billing models.Address, customerName string, issuedAt time.Time) models.Invoice {
+	draft := models.Invoice{
+		OrderId:        order.OrderId,
+		UserId:         order.UserId,
+		IssuedAt:       issuedAt,
+		Currency:       payment.Currency(),
+		Seller:         SellerFromEnv(),
+		CustomerName:   customerName,
+		BillingAddress: billing,
+		Lines:          make([]models.InvoiceLine, 0, len(order.LineItems)),
+		ItemsTotal:     order.ItemsTotal,
+		Shipping:       order.ShippingTotal,
+		Total:          order.Price,
+		PaymentMethod:  "cod",
+	}
+	if order.PaidAt != nil {
+		draft.PaidAt = *order.PaidAt
+	}
+	if order.Charge != nil {
+		draft.Currency = order.Charge.Currency
+	}
+	if order.PaymentMethod.Digital {
+		draft.PaymentMethod = "digital"
+	}
+	if order.Discount != nil {
+		draft.Discount = *order.Discount
+	}
+
+	// lines of the same product shipped to different destinations are billed together
+	index := make(map[string]int)
+	for _, line := range order.LineItems {
+		key := fmt.Sprintf("%s/%d", line.ProductId.Hex(), line.Price)
+		if i, ok := index[key]; ok {
+			draft.Lines[i].Quantity += line.Quantity
+			draft.Lines[i].Total += line.LineTotal()
+			continue
+		}
+		description := line.ProductId.Hex()
+		if line.ProductName != nil {
+			description = *line.ProductName
+		}
+		index[key] = len(draft.Lines)
+		draft.Lines = append(draft.Lines, models.InvoiceLine{
+			Description: description,
+			Quantity:    line.Quantity,
+			UnitPrice:   line.Price,
+			Total:       line.LineTotal(),
+		})
+	}
+
+	country := ""
+	if billing.Country != nil {
+		country = *billing.Country
+	}
+	draft.TaxRate = TaxRate(country)
+	draft.Tax = (draft.Total*draft.TaxRate + (10000+draft.TaxRate)/2) / (10000 + draft.TaxRate)
+	draft.Net = draft.Total - draft.Tax
+	return draft
+}
+
+// FormatAmount formats an amount in minor units, e.g. 1999 as "19.99 USD"
+func FormatAmount(amount int, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign, amount = "-", -amount
+	}
+	return fmt.Sprintf("%s%d.%02d %s", sign, amount/100, amount%100, currency)
+}
+
+// FormatRate formats a rate in basis points, e.g. 1950 as "19.5%"
+func FormatRate(rate int) string {
+	formatted := fmt.Sprintf("%d.%02d", rate/100, rate%100)
+	formatted = strings.TrimRight(strings.TrimRight(formatted, "0"), ".")
+	return formatted + "%"
+}
+
+// addressLines lists the non-empty lines of an address for printing
+func addressLines(address models.Address) []string {
+	lines := make([]string, 0, 5)
+	add := func(parts ...*string) {
+		values := make([]string, 0, len(parts))
+		for _, part := range parts {
+			if part != nil && strings.TrimSpace(*part) != "" {
+				values = append(values, strings.TrimSpace(*part))
+			}
+		}
+		if len(values) > 0 {
+			lines = append(lines, strings.Join(values, " "))
+		}
+	}
+	add(address.House, address.Street)
+	add(address.PinCode, address.City)
+	add(address.Region)
+	add(address.Country)
+	return lines
+}
@@ -0,0 +1,244 @@
+package invoice
+
+import (
+	"bytes"
+	"fmt"
+	"go-ecommerce/models"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A4 in points, and the margins the layout keeps to
+const (
+	pageWidth    = 595.0
+	pageHeight   = 842.0
+	marginLeft   = 50.0
+	marginRight  = pageWidth - 50.0
+	marginBottom = 70.0
+)
+
+// The three standard fonts used; every PDF reader has them, so nothing is embedded
+const (
+	fontRegular = "F1"
+	fontBold    = "F2"
+	fontMono    = "F3"
+)
+
+// pdfDocument lays out text and rules on pages and writes them as a minimal PDF 1.4 file
+type pdfDocument struct {
+	pages []*bytes.Buffer
+	page  *bytes.Buffer
+	y     float64
+}
+
+func newPDFDocument() *pdfDocument {
+	doc := &pdfDocument{}
+	doc.newPage()
+	return doc
+}
+
+func (doc *pdfDocument) newPage() {
+	doc.page = &bytes.Buffer{}
+	doc.pages = append(doc.pages, doc.page)
+	doc.y = pageHeight - 60
+}
+
+// ensureSpace starts a new page when less than height is left on this one
+func (doc *pdfDocument) ensureSpace(height float64) {
+	if doc.y-height < marginBottom {
+		doc.newPage()
+	}
+}
+
+func (doc *pdfDocument) text(font string, size, x, y float64, s string) {
+	fmt.Fprintf(doc.page, "BT /%s %s Tf %s %s Td (%s) Tj ET\n", font, number(size), number(x), number(y), pdfString(s))
+}
+
+// textRight writes s in the monospaced font so that it ends at right
+func (doc *pdfDocument) textRight(size, right, y float64, s string) {
+	// every Courier glyph is 600/1000 of the font size wide
+	width := float64(len([]rune(s))) * size * 0.6
+	doc.text(fontMono, size, right-width, y, s)
+}
+
+func (doc *pdfDocument) rule(y float64) {
+	fmt.Fprintf(doc.page, "0.5 w %s %s m %s %s l S\n", number(marginLeft), number(y), number(marginRight), number(y))
+}
+
+// write assembles the objects of the document, then the cross-reference table that
+// readers use to find them
+func (doc *pdfDocument) write(w io.Writer) error {
+	var out bytes.Buffer
+	offsets := make([]int, 0)
+	object := func(body string) {
+		offsets = append(offsets, out.Len())
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", len(offsets), body)
+	}
+
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+
+	// objects 1 to 5 are fixed; each page then takes two, the page and its contents
+	kids := make([]string, 0, len(doc.pages))
+	for i := range doc.pages {
+		kids = append(kids, strconv.Itoa(6+2*i)+" 0 R")
+	}
+	object("<< /Type /Catalog /Pages 2 0 R >>")
+	object(fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(doc.pages)))
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica-Bold /Encoding /WinAnsiEncoding >>")
+	object("<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>")
+	for i, page := range doc.pages {
+		object(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s %s] "+
+			"/Resources << /Font << /F1 3 0 R /F2 4 0 R /F3 5 0 R >> >> /Contents %d 0 R >>",
+			number(pageWidth), number(pageHeight), 7+2*i))
+		object(fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", page.Len(), page.String()))
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(offsets)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(offsets)+1, xref)
+
+	_, err := out.WriteTo(w)
+	return err
+}
+
+func number(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// winAnsi maps the characters outside Latin-1 that WinAnsiEncoding has
+var winAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92,
+	'“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+// pdfString encodes s for a PDF string literal. Characters the standard fonts cannot
+// show are replaced with "?".
+func pdfString(s string) string {
+	var encoded strings.Builder
+	for _, r := range s {
+		var b byte
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			encoded.WriteByte('\\')
+			b = byte(r)
+		case r >= 0x20 && r < 0x7f, r >= 0xa0 && r <= 0xff:
+			b = byte(r)
+		case r < 0x20:
+			b = ' '
+		default:
+			var ok bool
+			if b, ok = winAnsi[r]; !ok {
+				b = '?'
+			}
+		}
+		encoded.WriteByte(b)
+	}
+	return encoded.String()
+}
+
+// truncate shortens s to at most n characters
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// PDF renders the invoice as an A4 PDF document
+func PDF(w io.Writer, invoice models.Invoice) error {
+	money := func(amount int) string { return FormatAmount(amount, invoice.Currency) }
+	doc := newPDFDocument()
+
+	doc.text(fontBold, 20, marginLeft, doc.y, "Invoice "+invoice.Number)
+	doc.y -= 18
+	doc.text(fontRegular, 9, marginLeft, doc.y, "Issued "+invoice.IssuedAt.Format("2 January 2006")+
+		"  ·  Paid "+invoice.PaidAt.Format("2 January 2006")+"  ·  Order "+invoice.OrderId.Hex())
+	doc.y -= 40
+
+	seller := append([]string{}, strings.Split(invoice.Seller.Address, "\n")...)
+	if invoice.Seller.TaxId != "" {
+		seller = append(seller, "Tax ID "+invoice.Seller.TaxId)
+	}
+	customer := append([]string{invoice.CustomerName}, addressLines(invoice.BillingAddress)...)
+
+	top := doc.y
+	doc.text(fontBold, 11, marginLeft, doc.y, invoice.Seller.Name)
+	for _, line := range seller {
+		if line = strings.TrimSpace(line); line != "" {
+			doc.y -= 14
+			doc.text(fontRegular, 10, marginLeft, doc.y, line)
+		}
+	}
+	bottom := doc.y
+	doc.y = top
+	doc.text(fontBold, 11, 320, doc.y, "Bill to")
+	for _, line := range customer {
+		if line != "" {
+			doc.y -= 14
+			doc.text(fontRegular, 10, 320, doc.y, line)
+		}
+	}
+	if bottom < doc.y {
+		doc.y = bottom
+	}
+	doc.y -= 40
+
+	header := func() {
+		doc.text(fontBold, 10, marginLeft, doc.y, "Item")
+		doc.text(fontBold, 10, 330, doc.y, "Qty")
+		doc.text(fontBold, 10, 385, doc.y, "Unit price")
+		doc.text(fontBold, 10, 490, doc.y, "Amount")
+		doc.rule(doc.y - 5)
+		doc.y -= 20
+	}
+	header()
+	for _, line := range invoice.Lines {
+		if doc.y-16 < marginBottom {
+			doc.newPage()
+			header()
+		}
+		doc.text(fontRegular, 10, marginLeft, doc.y, truncate(line.Description, 48))
+		doc.textRight(9, 350, doc.y, strconv.Itoa(line.Quantity))
+		doc.textRight(9, 450, doc.y, money(line.UnitPrice))
+		doc.textRight(9, marginRight, doc.y, money(line.Total))
+		doc.y -= 16
+	}
+
+	type row struct{ label, amount, font string }
+	totals := []row{
+		{"Items", money(invoice.ItemsTotal), fontRegular},
+		{"Shipping", money(invoice.Shipping), fontRegular},
+	}
+	if invoice.Discount != 0 {
+		totals = append(totals, row{"Discount", money(-invoice.Discount), fontRegular})
+	}
+	totals = append(totals,
+		row{"Total", money(invoice.Total), fontBold},
+		row{"Net", money(invoice.Net), fontRegular},
+		row{"Tax " + FormatRate(invoice.TaxRate) + " (included)", money(invoice.Tax), fontRegular},
+	)
+
+	doc.ensureSpace(float64(len(totals))*16 + 50)
+	doc.rule(doc.y + 8)
+	doc.y -= 6
+	for _, total := range totals {
+		doc.text(total.font, 10, 330, doc.y, total.label)
+		doc.textRight(9, marginRight, doc.y, total.amount)
+		doc.y -= 16
+	}
+
+	doc.y -= 20
+	paid := "Paid by digital payment. Prices include tax."
+	if invoice.PaymentMethod == "cod" {
+		paid = "Paid cash on delivery. Prices include tax."
+	}
+	doc.text(fontRegular, 9, marginLeft, doc.y, paid)
+
+	return doc.write(w)
+}
@@ -0,0 +1,150 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"mime"
+	"mime/quotedprintable"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is a single outgoing email. HTML is optional; when set the message carries
+// both versions and the client picks one.
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+	// Tag names the kind of message, e.g. the template it was rendered from, and UserId
+	// the account it was sent to, for the delivery log
+	Tag    string
+	UserId string
+}
+
+// Mailer delivers transactional email
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// FromEnv picks the mailer configured by MAILER: "smtp" sends through the server in
+// SMTP_HOST, "file" writes messages to MAILER_DIR and anything else logs them to the
+// console. Failed sends are retried.
+func FromEnv() *Retrying {
+	var m Mailer = ConsoleMailer{}
+	switch strings.ToLower(os.Getenv("MAILER")) {
+	case "smtp":
+		m = SMTPFromEnv()
+	case "file":
+		dir := os.Getenv("MAILER_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		m = &FileMailer{Dir: dir}
+	}
+	return &Retrying{Mailer: m, Attempts: 3, Backoff: 2 * time.Second}
+}
+
+// sender reads MAIL_FROM, the From address of every message
+func sender() string {
+	if from := os.Getenv("MAIL_FROM"); from != "" {
+		return from
+	}
+	return "no-reply@localhost"
+}
+
+// ConsoleMailer logs every message instead of sending it
+type ConsoleMailer struct{}
+
+func (ConsoleMailer) Send(ctx context.Context, msg Message) error {
+	log.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Text)
+	return nil
+}
+
+// FileMailer drops every message as an .eml file into Dir, which is handy for local
+// development and tests.
+type FileMailer struct {
+	Dir string
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return err
+	}
+
+	body, err := msg.bytes(sender())
+	if err != nil {
+		return err
+	}
+	name := fmt.Sprintf("%d-%s.eml", time.Now().UnixNano(), sanitize(msg.To))
+	return os.WriteFile(filepath.Join(m.Dir, name), body, 0o600)
+}
+
+// bytes encodes the message as MIME, with a multipart/alternative body when it has
+// an HTML version
+func (msg Message) bytes(from string) ([]byte, error) {
+	var out bytes.Buffer
+	header := func(key, value string) {
+		fmt.Fprintf(&out, "%s: %s\r\n", key, value)
+	}
+	header("From", from)
+	header("To", msg.To)
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", time.Now().Format(time.RFC1123Z))
+	header("MIME-Version", "1.0")
+
+	if msg.HTML == "" {
+		header("Content-Type", "text/plain; charset=utf-8")
+		header("Content-Transfer-Encoding", "quoted-printable")
+		out.WriteString("\r\n")
+		if err := writeQuotedPrintable(&out, msg.Text); err != nil {
+			return nil, err
+		}
+		return out.Bytes(), nil
+	}
+
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, err
+	}
+	boundary := "b-" + hex.EncodeToString(raw)
+	header("Content-Type", `multipart/alternative; boundary="`+boundary+`"`)
+	out.WriteString("\r\n")
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		fmt.Fprintf(&out, "--%s\r\nContent-Type: %s\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n", boundary, part.contentType)
+		if err := writeQuotedPrintable(&out, part.body); err != nil {
+			return nil, err
+		}
+		out.WriteString("\r\n")
+	}
+	fmt.Fprintf(&out, "--%s--\r\n", boundary)
+	return out.Bytes(), nil
+}
+
+func writeQuotedPrintable(out *bytes.Buffer, body string) error {
+	w := quotedprintable.NewWriter(out)
+	if _, err := w.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// sanitize keeps an address usable as part of a file name
+func sanitize(address string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == '@':
+			return r
+		}
+		return '_'
+	}, address)
+}
@@ -0,0 +1,79 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"time"
+)
+
+// Delivery statuses
+const (
+	Delivered = "delivered"
+	Failed    = "failed"
+)
+
+// Delivery is the outcome of sending one message, after all retries
+type Delivery struct {
+	To       string
+	Subject  string
+	Tag      string
+	UserId   string
+	Status   string
+	Attempts int
+	Error    string
+	At       time.Time
+}
+
+// Retrying sends through Mailer, trying again with exponential backoff when a send
+// fails. Errors the server reports as permanent are not retried. OnDelivery, if set,
+// is told the outcome of every message.
+type Retrying struct {
+	Mailer     Mailer
+	Attempts   int
+	Backoff    time.Duration
+	OnDelivery func(ctx context.Context, delivery Delivery)
+}
+
+func (m *Retrying) Send(ctx context.Context, msg Message) error {
+	var err error
+	attempt := 0
+	for attempt < m.Attempts || attempt == 0 {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				err = ctx.Err()
+				m.report(msg, attempt, err)
+				return err
+			case <-time.After(m.Backoff << (attempt - 1)):
+			}
+		}
+		attempt++
+		if err = m.Mailer.Send(ctx, msg); err == nil || permanent(err) {
+			break
+		}
+	}
+	m.report(msg, attempt, err)
+	return err
+}
+
+func (m *Retrying) report(msg Message, attempts int, err error) {
+	if m.OnDelivery == nil {
+		return
+	}
+	delivery := Delivery{To: msg.To, Subject: msg.Subject, Tag: msg.Tag, UserId: msg.UserId, Status: Delivered, Attempts: attempts, At: time.Now()}
+	if err != nil {
+		delivery.Status = Failed
+		delivery.Error = err.Error()
+	}
+	// the log is written even if the send ran out of time
+	logCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m.OnDelivery(logCtx, delivery)
+}
+
+// permanent reports whether an SMTP server rejected the message for good
+func permanent(err error) bool {
+	var protocolErr *textproto.Error
+	return errors.As(err, &protocolErr) && protocolErr.Code >= 500
+}
@@ -1,32 +1,73 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"go-ecommerce/routes"
+	"log"
 	"os"
+	"time"
 
 	"go-ecommerce/controllers"
 	db "go-ecommerce/database"
+	"go-ecommerce/events"
 	"go-ecommerce/middleware"
+	"go-ecommerce/payment"
+	"go-ecommerce/token"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	bootstrapAdmin := flag.String("bootstrap-admin", "", "promote the user with this email to admin if no admin exists yet, then exit")
+	flag.Parse()
+
+	if *bootstrapAdmin != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := db.BootstrapAdmin(ctx, db.UserData(db.Client, "Users"), *bootstrapAdmin); err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("%s is now an admin", *bootstrapAdmin)
+		return
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8000"
 	}
 
-	app := controllers.NewApplication(db.ProductData(db.Client, "Products"), db.UserData(db.Client, ""))
+	provider, err := payment.FromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if provider == nil {
+		log.Println("PAYMENT_PROVIDER is not set, only cash on delivery is accepted")
+	}
+	controllers.PaymentProvider = provider
+
+	token.StartKeyRotation(token.RotationInterval())
+	controllers.StartDeletionJob(time.Hour)
+
+	app := controllers.NewApplication(db.ProductData(db.Client, "Products"), db.UserData(db.Client, "Users"), db.OrderData(db.Client, "Orders"))
+	app.RegisterSubscribers()
+	events.StartRelay(controllers.OutboxCollection, time.Second)
 
 	router := gin.New()
 	router.Use(gin.Logger())
 
 	routes.UserRoutes(router)
-	router.Use(middleware.Authentication())
+	routes.AddressRoutes(router)
+	routes.OrderRoutes(router, app)
+	routes.AdminRoutes(router, app)
+
+	// customer routes accept bearer tokens as well as the browser session cookie
+	user := router.Group("", middleware.SessionAuthentication())
+	user.GET("/addtocart", app.AddToCart())
+	user.GET("/removeitem", app.RemoveItem())
+	user.GET("/listcart", app.GetItemFromCart())
+	user.GET("/cartcheckout", app.BuyFromCart())
+	user.GET("/instantbuy", app.InstantBuy())
 
-	router.GET("/addtocart", app.AddToCart())
-	router.GET("/removeitem", app.RemoveItem())
-	router.GET("/cartcheckout", app.BuyFromCart())
-	router.GET("/instantbuy", app.InstantBuy())
+	log.Fatal(router.Run(":" + port))
 }
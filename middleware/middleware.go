@@ -1,30 +1,106 @@
 package middleware
 
 import (
+	"context"
+	"go-ecommerce/database"
+	"go-ecommerce/models"
 	"go-ecommerce/token"
+	"log"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+var SessionCollection *mongo.Collection = database.SessionData(database.Client, "Sessions")
+
+// Authentication accepts an access token from the Authorization: Bearer header, or from
+// the legacy token header.
 func Authentication() gin.HandlerFunc {
-	return func (c *gin.Context)  {
-		clientToken := c.Request.Header.Get("token")
+	return authenticate(false)
+}
+
+// SessionAuthentication additionally accepts the HttpOnly session cookie set by
+// SetSessionCookies, for browser clients. Requests authenticated by cookie must pass
+// the CSRF double-submit check.
+func SessionAuthentication() gin.HandlerFunc {
+	return authenticate(true)
+}
+
+func authenticate(allowCookie bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientToken := headerToken(c)
+		if clientToken == "" && allowCookie {
+			if cookie, err := c.Cookie(SessionCookie); err == nil && cookie != "" {
+				if !ValidCSRF(c) {
+					c.JSON(http.StatusForbidden, gin.H{"error": "Missing or invalid CSRF token"})
+					c.Abort()
+					return
+				}
+				clientToken = cookie
+			}
+		}
 		if clientToken == "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "No authorization header provided"})
 			c.Abort()
 			return
 		}
 
-		claims, errMsg := token.ValidateToken(clientToken)
+		claims, errMsg := token.ValidateToken(clientToken, token.AccessToken)
 		if errMsg != "" {
 			c.JSON(http.StatusUnauthorized, gin.H{"error": errMsg})
 			c.Abort()
 			return
 		}
 
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+		session, err := database.ActiveSession(ctx, SessionCollection, claims.SessionId, claims.Uid)
+		if err == database.ErrSessionNotFound {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "Session has expired or been revoked"})
+			c.Abort()
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Could not verify session"})
+			c.Abort()
+			return
+		}
+		if err := database.TouchSession(ctx, SessionCollection, session, c.ClientIP()); err != nil {
+			log.Println(err)
+		}
+
 		c.Set("email", claims.Email)
 		c.Set("uid", claims.Uid)
+		c.Set("role", claims.Role)
+		c.Set("sid", claims.SessionId)
+		c.Next()
+	}
+}
+
+// headerToken returns the bearer token of the request, if any
+func headerToken(c *gin.Context) string {
+	if header := c.Request.Header.Get("Authorization"); header != "" {
+		scheme, credentials, found := strings.Cut(header, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(credentials)
+		}
+		return ""
+	}
+	return c.Request.Header.Get("token")
+}
+
+// RequirePermission allows the request through only when the role of the authenticated
+// user grants the given permission. It must run after Authentication.
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !models.HasPermission(c.GetString("role"), permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Insufficient permissions"})
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
-}
\ No newline at end of file
+}
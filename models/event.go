@@ -0,0 +1,53 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Domain event types
+const (
+	EventOrderPlaced    = "OrderPlaced"
+	EventOrderPaid      = "OrderPaid"
+	EventOrderCancelled = "OrderCancelled"
+	EventShipmentSent   = "ShipmentSent"
+	EventRefundIssued   = "RefundIssued"
+	EventCartUpdated    = "CartUpdated"
+	EventProductChanged = "ProductChanged"
+	EventUserSignedUp   = "UserSignedUp"
+)
+
+// Event is a domain event in the outbox. It is written in the same transaction as the
+// change it describes and is relayed to the subscribers of its type afterwards.
+type Event struct {
+	EventId primitive.ObjectID `json:"eventId" bson:"_id"`
+	Type    string             `json:"type" bson:"type"`
+	// SubjectId is the order, shipment, ledger entry, product or user the event is about
+	SubjectId  string    `json:"subjectId" bson:"subjectId"`
+	UserId     string    `json:"userId" bson:"userId,omitempty"`
+	OccurredAt time.Time `json:"occurredAt" bson:"occurredAt"`
+	// HandledBy lists the subscribers that have handled the event, so a retry only
+	// goes to the ones that failed
+	HandledBy []string `json:"handledBy" bson:"handledBy"`
+	Attempts  int      `json:"attempts" bson:"attempts"`
+	LastError string   `json:"lastError" bson:"lastError,omitempty"`
+	// AvailableAt is when the relay may pick the event up next; DispatchedAt is set once
+	// every subscriber has handled it
+	AvailableAt  time.Time  `json:"availableAt" bson:"availableAt"`
+	DispatchedAt *time.Time `json:"dispatchedAt" bson:"dispatchedAt,omitempty"`
+}
+
+// NewEvent creates an event of eventType about subjectId that is due for relaying
+func NewEvent(eventType, subjectId, userId string) Event {
+	now := time.Now()
+	return Event{
+		EventId:     primitive.NewObjectID(),
+		Type:        eventType,
+		SubjectId:   subjectId,
+		UserId:      userId,
+		OccurredAt:  now,
+		HandledBy:   make([]string, 0),
+		AvailableAt: now,
+	}
+}
@@ -0,0 +1,51 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Invoice is the bill issued for a paid order. It is a snapshot: once issued it never
+// changes, whatever happens to the order, the user or the product catalogue later.
+// Invoices are numbered per year without gaps, and Number is unique.
+type Invoice struct {
+	Number         string             `json:"number" bson:"_id"`
+	Year           int                `json:"year" bson:"year"`
+	Sequence       int                `json:"sequence" bson:"sequence"`
+	OrderId        primitive.ObjectID `json:"orderId" bson:"orderId"`
+	UserId         string             `json:"userId" bson:"userId"`
+	IssuedAt       time.Time          `json:"issuedAt" bson:"issuedAt"`
+	PaidAt         time.Time          `json:"paidAt" bson:"paidAt"`
+	Currency       string             `json:"currency" bson:"currency"`
+	Seller         Seller             `json:"seller" bson:"seller"`
+	CustomerName   string             `json:"customerName" bson:"customerName"`
+	BillingAddress Address            `json:"billingAddress" bson:"billingAddress"`
+	Lines          []InvoiceLine      `json:"lines" bson:"lines"`
+	ItemsTotal     int                `json:"itemsTotal" bson:"itemsTotal"`
+	Shipping       int                `json:"shipping" bson:"shipping"`
+	Discount       int                `json:"discount" bson:"discount"`
+	// Total is what was paid. Prices include tax; TaxRate, in basis points, splits
+	// Total into Net and Tax.
+	Total   int `json:"total" bson:"total"`
+	TaxRate int `json:"taxRate" bson:"taxRate"`
+	Tax     int `json:"tax" bson:"tax"`
+	Net     int `json:"net" bson:"net"`
+	// PaymentMethod is "digital" or "cod"
+	PaymentMethod string `json:"paymentMethod" bson:"paymentMethod"`
+}
+
+// InvoiceLine is one product on an invoice
+type InvoiceLine struct {
+	Description string `json:"description" bson:"description"`
+	Quantity    int    `json:"quantity" bson:"quantity"`
+	UnitPrice   int    `json:"unitPrice" bson:"unitPrice"`
+	Total       int    `json:"total" bson:"total"`
+}
+
+// Seller is the business issuing invoices
+type Seller struct {
+	Name    string `json:"name" bson:"name"`
+	Address string `json:"address" bson:"address"`
+	TaxId   string `json:"taxId" bson:"taxId,omitempty"`
+}
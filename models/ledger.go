@@ -0,0 +1,63 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Ledger entry kinds
+const (
+	LedgerCharge = "charge"
+	LedgerVoid   = "void"
+	LedgerRefund = "refund"
+)
+
+// Ledger entry statuses. A refund is written down as pending before any money moves
+// and settled as completed or failed afterwards; charges and voids are completed when
+// they are written.
+const (
+	LedgerPending   = "pending"
+	LedgerCompleted = "completed"
+	LedgerFailed    = "failed"
+)
+
+// Ways money is paid back
+const (
+	RefundToProvider    = "provider"
+	RefundToStoreCredit = "store_credit"
+)
+
+// What a refund was made for
+const (
+	RefundForReturn       = "return"
+	RefundForCancellation = "cancellation"
+	RefundForGoodwill     = "goodwill"
+)
+
+// LedgerEntry records one movement of money for an order. Entries are only ever added,
+// and only the status of a pending refund changes once it is settled, so the ledger is
+// the history of every payment and refund.
+type LedgerEntry struct {
+	EntryId       primitive.ObjectID  `json:"entryId" bson:"_id"`
+	OrderId       primitive.ObjectID  `json:"orderId" bson:"orderId"`
+	UserId        string              `json:"userId" bson:"userId"`
+	Kind          string              `json:"kind" bson:"kind"`
+	Status        string              `json:"status" bson:"status"`
+	Amount        int                 `json:"amount" bson:"amount"`
+	Currency      string              `json:"currency" bson:"currency"`
+	Method        string              `json:"method" bson:"method,omitempty"`
+	Source        string              `json:"source" bson:"source,omitempty"`
+	Reason        string              `json:"reason" bson:"reason,omitempty"`
+	Lines         []RefundLine        `json:"lines" bson:"lines,omitempty"`
+	ReturnId      *primitive.ObjectID `json:"returnId" bson:"returnId,omitempty"`
+	TransactionId string              `json:"transactionId" bson:"transactionId,omitempty"`
+	CreatedBy     string              `json:"createdBy" bson:"createdBy,omitempty"`
+	CreatedAt     time.Time           `json:"createdAt" bson:"createdAt"`
+}
+
+// RefundLine is the part of a refund paid back for one order line
+type RefundLine struct {
+	LineId primitive.ObjectID `json:"lineId" bson:"lineId"`
+	Amount int                `json:"amount" bson:"amount"`
+}
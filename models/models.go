@@ -1,27 +1,61 @@
 package models
 
 import (
+	"errors"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
-// User object
+// User is the database document of a user. It is never serialized to JSON: API
+// responses go through the dto package, which copies only the fields meant to be seen.
 type User struct {
-	ID             primitive.ObjectID `json:"_id" bson:"_id"`
-	FirstName      *string            `json:"firstName" validate:"required,min=2,max=30"`
-	LastName       *string            `json:"lastName" validate:"required,min=2,max=30"`
-	Password       *string            `json:"password" validate:"required,min=6"`
-	Email          *string            `json:"email" validate:"required"`
-	Phone          *string            `json:"phone" validate:"required"`
-	Token          *string            `json:"token"`
-	RefreshToken   *string            `json:"refreshToken"`
-	CreatedAt      time.Time          `json:"createdAt"`
-	UpdatedAt      time.Time          `json:"updatedAt"`
-	UserCart       []UserProduct      `json:"userCart" bson:"userCart"`
-	AddressDetails []Address          `json:"addressDetails" bson:"addressDetails"`
-	OrderStatus    []Order            `json:"orders" bson:"orders"`
-	UserId         string             `json:"userId"`
+	ID                primitive.ObjectID `json:"_id" bson:"_id"`
+	FirstName         *string            `json:"firstName"`
+	LastName          *string            `json:"lastName"`
+	Password          *string            `json:"-"`
+	Email             *string            `json:"email"`
+	Phone             *string            `json:"phone"`
+	Token             *string            `json:"-"`
+	RefreshToken      *string            `json:"-"`
+	CreatedAt         time.Time          `json:"createdAt"`
+	UpdatedAt         time.Time          `json:"updatedAt"`
+	UserCart          []UserProduct      `json:"userCart" bson:"userCart"`
+	AddressDetails    []Address          `json:"addressDetails" bson:"addressDetails"`
+	OrderStatus       []Order            `json:"orders" bson:"orders"`
+	UserId            string             `json:"userId"`
+	Role              string             `json:"role" bson:"role"`
+	EmailVerified     bool               `json:"emailVerified" bson:"emailVerified"`
+	EmailVerifiedAt   *time.Time         `json:"emailVerifiedAt" bson:"emailVerifiedAt,omitempty"`
+	TOTPEnabled       bool               `json:"totpEnabled" bson:"totpEnabled"`
+	TOTPSecret        *string            `json:"-" bson:"totpSecret,omitempty"`
+	TOTPPendingSecret *string            `json:"-" bson:"totpPendingSecret,omitempty"`
+	TOTPLastStep      int64              `json:"-" bson:"totpLastStep"`
+	RecoveryCodes     []string           `json:"-" bson:"recoveryCodes,omitempty"`
+	Identities        []ExternalIdentity `json:"identities" bson:"identities,omitempty"`
+	PendingEmail      *string            `json:"pendingEmail" bson:"pendingEmail,omitempty"`
+	DeletionDueAt     *time.Time         `json:"deletionDueAt" bson:"deletionDueAt,omitempty"`
+	DeletedAt         *time.Time         `json:"deletedAt" bson:"deletedAt,omitempty"`
+	StoreCredit       int                `json:"storeCredit" bson:"storeCredit"`
+	// Locale is the language emails are sent in, e.g. "de"; empty means the default
+	Locale string `json:"locale" bson:"locale,omitempty"`
+}
+
+// ErrUserNotSerializable is returned when a User is marshalled to JSON directly
+var ErrUserNotSerializable = errors.New("models.User must not be serialized, map it to a dto.UserResponse")
+
+// MarshalJSON fails closed so a User handed to the response writer by mistake causes an
+// error instead of leaking whatever fields it has
+func (User) MarshalJSON() ([]byte, error) {
+	return nil, ErrUserNotSerializable
+}
+
+// ExternalIdentity links a user to their account at an OpenID Connect provider
+type ExternalIdentity struct {
+	Provider string    `json:"provider" bson:"provider"`
+	Subject  string    `json:"subject" bson:"subject"`
+	Email    string    `json:"email" bson:"email"`
+	LinkedAt time.Time `json:"linkedAt" bson:"linkedAt"`
 }
 
 type Product struct {
@@ -30,6 +64,9 @@ type Product struct {
 	Price       *uint64            `json:"price"`
 	Rating      *uint8             `json:"rating"`
 	Image       *string            `json:"image"`
+	// Stock is the number of units available. Products without it are not tracked
+	// and never run out.
+	Stock *int `json:"stock" bson:"stock,omitempty"`
 }
 
 type UserProduct struct {
@@ -40,24 +77,94 @@ type UserProduct struct {
 	Image       *string            `json:"image"`
 }
 
+// Address is one entry of a user's address book. At most one address is the default
+// for shipping and at most one the default for billing.
 type Address struct {
-	AddressId primitive.ObjectID `bson:"_id"`
-	House     *string            `json:"house" bson:"house"`
-	Street    *string            `json:"street" bson:"street"`
-	City      *string            `json:"city" bson:"city"`
-	PinCode   *string            `json:"pinCode" bson:"pinCode"`
+	AddressId       primitive.ObjectID `json:"addressId" bson:"_id"`
+	Label           *string            `json:"label" bson:"label"`
+	House           *string            `json:"house" bson:"house"`
+	Street          *string            `json:"street" bson:"street"`
+	City            *string            `json:"city" bson:"city"`
+	Region          *string            `json:"region" bson:"region,omitempty"`
+	PinCode         *string            `json:"pinCode" bson:"pinCode"`
+	Country         *string            `json:"country" bson:"country,omitempty"`
+	DefaultShipping bool               `json:"defaultShipping" bson:"defaultShipping"`
+	DefaultBilling  bool               `json:"defaultBilling" bson:"defaultBilling"`
+}
+
+// AuditEntry records a privileged action, such as an admin operating on another user's cart
+type AuditEntry struct {
+	ID           primitive.ObjectID `json:"_id" bson:"_id"`
+	ActorId      string             `json:"actorId" bson:"actorId"`
+	TargetUserId string             `json:"targetUserId" bson:"targetUserId"`
+	Action       string             `json:"action" bson:"action"`
+	IP           string             `json:"ip" bson:"ip"`
+	CreatedAt    time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// EmailDelivery is the outcome of sending one email, kept as a delivery log
+type EmailDelivery struct {
+	ID        primitive.ObjectID `json:"_id" bson:"_id"`
+	UserId    string             `json:"userId" bson:"userId,omitempty"`
+	To        string             `json:"to" bson:"to"`
+	Subject   string             `json:"subject" bson:"subject"`
+	Template  string             `json:"template" bson:"template,omitempty"`
+	Status    string             `json:"status" bson:"status"`
+	Attempts  int                `json:"attempts" bson:"attempts"`
+	Error     string             `json:"error" bson:"error,omitempty"`
+	CreatedAt time.Time          `json:"createdAt" bson:"createdAt"`
+}
+
+// Purposes an ActionToken can be issued for
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+	PurposeMagicLink         = "magic_link"
+	PurposeEmailChange       = "email_change"
+)
+
+// ActionToken is a single-use token emailed to a user, e.g. to reset their password.
+// Only the SHA-256 hash of the token is stored.
+type ActionToken struct {
+	ID        primitive.ObjectID `bson:"_id"`
+	UserId    string             `bson:"userId"`
+	Purpose   string             `bson:"purpose"`
+	TokenHash string             `bson:"tokenHash"`
+	ExpiresAt time.Time          `bson:"expiresAt"`
+	UsedAt    *time.Time         `bson:"usedAt"`
+	CreatedAt time.Time          `bson:"createdAt"`
+}
+
+// LoginAttempt counts recent failed logins for one key, either an account email or a
+// client IP, and the lockout they caused
+type LoginAttempt struct {
+	Key           string     `bson:"_id"`
+	Failures      int        `bson:"failures"`
+	LastFailureAt time.Time  `bson:"lastFailureAt"`
+	LockedUntil   *time.Time `bson:"lockedUntil,omitempty"`
 }
 
-type Order struct {
-	OrderId       primitive.ObjectID `bson:"_id"`
-	OrderCart     []UserProduct      `json:"orderList" bson:"orderList"`
-	OrderedAt     time.Time          `json:"orderedAt" bson:"orderedAt"`
-	Price         int                `json:"totalPrice" bson:"totalPrice"`
-	Discount      *int               `json:"discount" bson:"discount"`
-	PaymentMethod Payment            `json:"paymentMethod" bson:"paymentMethod"`
+// Session is one signed in device. Every access and refresh token names the session it
+// belongs to, and revoking the session invalidates them.
+type Session struct {
+	ID               primitive.ObjectID `json:"id" bson:"_id"`
+	UserId           string             `json:"-" bson:"userId"`
+	UserAgent        string             `json:"userAgent" bson:"userAgent"`
+	IP               string             `json:"ip" bson:"ip"`
+	CreatedAt        time.Time          `json:"createdAt" bson:"createdAt"`
+	LastSeenAt       time.Time          `json:"lastSeenAt" bson:"lastSeenAt"`
+	ExpiresAt        time.Time          `json:"expiresAt" bson:"expiresAt"`
+	RevokedAt        *time.Time         `json:"revokedAt,omitempty" bson:"revokedAt,omitempty"`
+	RefreshTokenHash string             `json:"-" bson:"refreshTokenHash"`
 }
 
-type Payment struct {
-	Digital bool
-	COD     bool
+// OAuthState is the server side half of an OpenID Connect login in progress. It is keyed
+// by the hash of the state parameter and consumed by the callback.
+type OAuthState struct {
+	ID            string    `bson:"_id"`
+	Provider      string    `bson:"provider"`
+	Nonce         string    `bson:"nonce"`
+	CodeVerifier  string    `bson:"codeVerifier"`
+	CookieSession bool      `bson:"cookieSession"`
+	ExpiresAt     time.Time `bson:"expiresAt"`
 }
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Order statuses. An order moves from placed to shipped as its destinations ship.
+const (
+	OrderPlaced           = "placed"
+	OrderPartiallyShipped = "partially_shipped"
+	OrderShipped          = "shipped"
+	OrderDelivered        = "delivered"
+	OrderCancelled        = "cancelled"
+)
+
+// Order is one checkout. Its line items may ship to several destinations, each with
+// its own shipping quote, but the whole order is paid with a single charge. Orders are
+// stored in their own collection; OrderCart is only set on orders from before that,
+// which are still embedded in the user document.
+type Order struct {
+	OrderId       primitive.ObjectID `json:"orderId" bson:"_id"`
+	UserId        string             `json:"userId" bson:"userId,omitempty"`
+	Status        string             `json:"status" bson:"status,omitempty"`
+	OrderCart     []UserProduct      `json:"orderList,omitempty" bson:"orderList,omitempty"`
+	LineItems     []LineItem         `json:"lineItems" bson:"lineItems,omitempty"`
+	Destinations  []Destination      `json:"destinations" bson:"destinations,omitempty"`
+	OrderedAt     time.Time          `json:"orderedAt" bson:"orderedAt"`
+	ItemsTotal    int                `json:"itemsTotal" bson:"itemsTotal"`
+	ShippingTotal int                `json:"shippingTotal" bson:"shippingTotal"`
+	Price         int                `json:"totalPrice" bson:"totalPrice"`
+	Discount      *int               `json:"discount" bson:"discount"`
+	PaymentMethod Payment            `json:"paymentMethod" bson:"paymentMethod"`
+	Charge        *Charge            `json:"charge" bson:"charge,omitempty"`
+	PaidAt        *time.Time         `json:"paidAt" bson:"paidAt,omitempty"`
+	DeliveredAt   *time.Time         `json:"deliveredAt" bson:"deliveredAt,omitempty"`
+	// RefundedAmount is the sum of all refunds, which never exceeds CapturedAmount
+	RefundedAmount int `json:"refundedAmount" bson:"refundedAmount"`
+	// InvoiceNumber is set once the invoice for the paid order has been issued;
+	// InvoiceClaimedAt while it is being issued
+	InvoiceNumber    string        `json:"invoiceNumber" bson:"invoiceNumber,omitempty"`
+	InvoiceClaimedAt *time.Time    `json:"-" bson:"invoiceClaimedAt,omitempty"`
+	Cancellation     *Cancellation `json:"cancellation" bson:"cancellation,omitempty"`
+}
+
+// Cancellation records who cancelled an order, when and why
+type Cancellation struct {
+	Reason      string    `json:"reason" bson:"reason"`
+	CancelledBy string    `json:"cancelledBy" bson:"cancelledBy"`
+	CancelledAt time.Time `json:"cancelledAt" bson:"cancelledAt"`
+}
+
+// CapturedAmount is how much was actually paid for the order. A digital payment counts
+// from checkout until it is voided; cash on delivery counts once it has been collected,
+// which is when the order is delivered and marked paid.
+func (order Order) CapturedAmount() int {
+	if order.Charge != nil {
+		if order.Charge.Status == ChargeVoided {
+			return 0
+		}
+		return order.Charge.Amount
+	}
+	if order.PaidAt != nil {
+		return order.Price
+	}
+	return 0
+}
+
+// LineTotal is what a line cost
+func (line LineItem) LineTotal() int {
+	return line.Price * line.Quantity
+}
+
+type Payment struct {
+	Digital bool
+	COD     bool
+}
+
+// LineItem is a quantity of one product in an order, bound for one destination.
+// ShippedQuantity counts how many of them have left in shipments so far,
+// ReturnedQuantity how many are in returns that were not rejected and RefundedAmount
+// how much of the line was paid back.
+type LineItem struct {
+	LineId           primitive.ObjectID `json:"lineId" bson:"_id"`
+	ProductId        primitive.ObjectID `json:"productId" bson:"productId"`
+	ProductName      *string            `json:"productName" bson:"productName"`
+	Price            int                `json:"price" bson:"price"`
+	Quantity         int                `json:"quantity" bson:"quantity"`
+	ShippedQuantity  int                `json:"shippedQuantity" bson:"shippedQuantity"`
+	ReturnedQuantity int                `json:"returnedQuantity" bson:"returnedQuantity"`
+	RefundedAmount   int                `json:"refundedAmount" bson:"refundedAmount"`
+	DestinationId    primitive.ObjectID `json:"destinationId" bson:"destinationId"`
+}
+
+// Destination is one address an order ships to. It becomes its own shipment.
+type Destination struct {
+	DestinationId primitive.ObjectID `json:"destinationId" bson:"_id"`
+	Address       Address            `json:"address" bson:"address"`
+	Quote         ShippingQuote      `json:"shippingQuote" bson:"shippingQuote"`
+}
+
+// ShippingQuote is the price and expected speed of shipping to one destination
+type ShippingQuote struct {
+	Carrier       string `json:"carrier" bson:"carrier"`
+	Service       string `json:"service" bson:"service"`
+	Amount        int    `json:"amount" bson:"amount"`
+	EstimatedDays int    `json:"estimatedDays" bson:"estimatedDays"`
+}
+
+// Charge statuses
+const (
+	ChargeCaptured = "captured"
+	ChargeVoided   = "voided"
+	ChargeRefunded = "refunded"
+)
+
+// Charge is the payment taken for a digitally paid order through a payment provider
+type Charge struct {
+	Provider      string    `json:"provider" bson:"provider"`
+	TransactionId string    `json:"transactionId" bson:"transactionId"`
+	Amount        int       `json:"amount" bson:"amount"`
+	Currency      string    `json:"currency" bson:"currency"`
+	Status        string    `json:"status" bson:"status"`
+	CreatedAt     time.Time `json:"createdAt" bson:"createdAt"`
+}
@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Return statuses. A requested return is approved or rejected by staff; an approved
+// one is received when the goods arrive back and completed once it has been refunded.
+const (
+	ReturnRequested = "requested"
+	ReturnApproved  = "approved"
+	ReturnRejected  = "rejected"
+	ReturnReceived  = "received"
+	ReturnCompleted = "completed"
+)
+
+// Return is a customer's request to send back items of a delivered order (an RMA)
+type Return struct {
+	ReturnId     primitive.ObjectID `json:"returnId" bson:"_id"`
+	OrderId      primitive.ObjectID `json:"orderId" bson:"orderId"`
+	UserId       string             `json:"userId" bson:"userId"`
+	Items        []ReturnItem       `json:"items" bson:"items"`
+	Note         string             `json:"note" bson:"note,omitempty"`
+	Status       string             `json:"status" bson:"status"`
+	ReviewedBy   string             `json:"reviewedBy" bson:"reviewedBy,omitempty"`
+	ReviewNote   string             `json:"reviewNote" bson:"reviewNote,omitempty"`
+	Restocked    bool               `json:"restocked" bson:"restocked"`
+	RefundAmount int                `json:"refundAmount" bson:"refundAmount"`
+	// RefundId is the ledger entry of the refund
+	RefundId    string     `json:"refundId" bson:"refundId,omitempty"`
+	CreatedAt   time.Time  `json:"createdAt" bson:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt" bson:"updatedAt"`
+	ReceivedAt  *time.Time `json:"receivedAt" bson:"receivedAt,omitempty"`
+	CompletedAt *time.Time `json:"completedAt" bson:"completedAt,omitempty"`
+}
+
+// ReturnItem is a quantity of one order line being sent back and why
+type ReturnItem struct {
+	LineId   primitive.ObjectID `json:"lineId" bson:"lineId"`
+	Quantity int                `json:"quantity" bson:"quantity"`
+	Reason   string             `json:"reason" bson:"reason"`
+}
@@ -0,0 +1,40 @@
+package models
+
+// Roles a user can hold. New accounts are always customers; any other role has to
+// be granted by an admin.
+const (
+	RoleCustomer       = "customer"
+	RoleSupport        = "support"
+	RoleCatalogManager = "catalog_manager"
+	RoleAdmin          = "admin"
+)
+
+// Permissions checked by middleware.RequirePermission
+const (
+	PermManageCarts    = "carts:manage"
+	PermManageOrders   = "orders:manage"
+	PermManageProducts = "products:manage"
+	PermManageUsers    = "users:manage"
+)
+
+// RolePermissions lists what each role is allowed to do
+var RolePermissions = map[string][]string{
+	RoleCustomer:       {},
+	RoleSupport:        {PermManageCarts, PermManageOrders},
+	RoleCatalogManager: {PermManageProducts},
+	RoleAdmin:          {PermManageCarts, PermManageOrders, PermManageProducts, PermManageUsers},
+}
+
+func IsValidRole(role string) bool {
+	_, ok := RolePermissions[role]
+	return ok
+}
+
+func HasPermission(role, permission string) bool {
+	for _, granted := range RolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Shipment statuses, in the order a parcel normally goes through them. A shipment can
+// move to exception from any status and back once the carrier resolves it.
+const (
+	ShipmentShipped        = "shipped"
+	ShipmentInTransit      = "in_transit"
+	ShipmentOutForDelivery = "out_for_delivery"
+	ShipmentDelivered      = "delivered"
+	ShipmentException      = "exception"
+)
+
+// Shipment is one parcel sent to a destination of an order. A destination can be sent
+// in several shipments when not everything is in stock at once.
+type Shipment struct {
+	ShipmentId     primitive.ObjectID `json:"shipmentId" bson:"_id"`
+	OrderId        primitive.ObjectID `json:"orderId" bson:"orderId"`
+	UserId         string             `json:"userId" bson:"userId"`
+	DestinationId  primitive.ObjectID `json:"destinationId" bson:"destinationId"`
+	Carrier        string             `json:"carrier" bson:"carrier"`
+	TrackingNumber string             `json:"trackingNumber" bson:"trackingNumber"`
+	TrackingURL    string             `json:"trackingUrl" bson:"trackingUrl,omitempty"`
+	Items          []ShipmentItem     `json:"items" bson:"items"`
+	Status         string             `json:"status" bson:"status"`
+	Events         []ShipmentEvent    `json:"events" bson:"events"`
+	CreatedAt      time.Time          `json:"createdAt" bson:"createdAt"`
+	UpdatedAt      time.Time          `json:"updatedAt" bson:"updatedAt"`
+}
+
+// ShipmentItem is a quantity of one order line in a shipment
+type ShipmentItem struct {
+	LineId   primitive.ObjectID `json:"lineId" bson:"lineId"`
+	Quantity int                `json:"quantity" bson:"quantity"`
+}
+
+// ShipmentEvent is one tracking update, as reported by the carrier
+type ShipmentEvent struct {
+	Status      string    `json:"status" bson:"status"`
+	Description string    `json:"description" bson:"description,omitempty"`
+	Location    string    `json:"location" bson:"location,omitempty"`
+	OccurredAt  time.Time `json:"occurredAt" bson:"occurredAt"`
+}
+
+func IsValidShipmentStatus(status string) bool {
+	switch status {
+	case ShipmentShipped, ShipmentInTransit, ShipmentOutForDelivery, ShipmentDelivered, ShipmentException:
+		return true
+	}
+	return false
+}
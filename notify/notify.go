@@ -0,0 +1,265 @@
+// Package notify renders the transactional emails sent to customers. Every message has
+// a subject, a plain text and an HTML version, written per language in
+// templates/<locale>/<name>.tmpl; a language without a translation falls back to English.
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"go-ecommerce/invoice"
+	"go-ecommerce/mailer"
+	"go-ecommerce/models"
+	"go-ecommerce/payment"
+	htmltemplate "html/template"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Messages that can be sent
+const (
+	OrderConfirmation = "order_confirmation"
+	ShipmentSent      = "shipment"
+	OrderCancelled    = "cancellation"
+	RefundIssued      = "refund"
+	PasswordReset     = "password_reset"
+)
+
+// DefaultLocale is used for users without a language and for missing translations
+const DefaultLocale = "en"
+
+//go:embed templates
+var files embed.FS
+
+// dateFormats are how dates are written in each language
+var dateFormats = map[string]string{
+	"en": "2 January 2006",
+	"de": "2.1.2006",
+}
+
+// message is one message in one language
+type message struct {
+	text *texttemplate.Template
+	html *htmltemplate.Template
+}
+
+// messages are keyed by locale and then name
+var messages = loadMessages()
+
+func loadMessages() map[string]map[string]message {
+	layout, err := fs.ReadFile(files, "templates/layout.html")
+	if err != nil {
+		log.Fatal(err)
+	}
+	paths, err := fs.Glob(files, "templates/*/*.tmpl")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	loaded := make(map[string]map[string]message)
+	for _, file := range paths {
+		source, err := fs.ReadFile(files, file)
+		if err != nil {
+			log.Fatal(err)
+		}
+		locale := path.Base(path.Dir(file))
+		name := strings.TrimSuffix(path.Base(file), ".tmpl")
+		funcs := map[string]interface{}{
+			"lang": func() string { return locale },
+			"date": func(t time.Time) string { return t.Format(dateFormat(locale)) },
+		}
+
+		text := texttemplate.Must(texttemplate.New(name).Funcs(funcs).Parse(string(source)))
+		html := htmltemplate.Must(htmltemplate.New(name).Funcs(funcs).Parse(string(source)))
+		htmltemplate.Must(html.Parse(string(layout)))
+		if loaded[locale] == nil {
+			loaded[locale] = make(map[string]message)
+		}
+		loaded[locale][name] = message{text: text, html: html}
+	}
+	return loaded
+}
+
+func dateFormat(locale string) string {
+	if format, ok := dateFormats[locale]; ok {
+		return format
+	}
+	return dateFormats[DefaultLocale]
+}
+
+// Locales lists the languages messages are available in
+func Locales() []string {
+	locales := make([]string, 0, len(messages))
+	for locale := range messages {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// IsSupportedLocale reports whether there are messages in locale
+func IsSupportedLocale(locale string) bool {
+	_, ok := messages[locale]
+	return ok
+}
+
+// Recipient is who a message goes to
+type Recipient struct {
+	UserId string
+	Email  string
+	Name   string
+	Locale string
+}
+
+// RecipientOf addresses a message to user, in their language
+func RecipientOf(user models.User) Recipient {
+	recipient := Recipient{UserId: user.UserId, Locale: user.Locale}
+	if user.Email != nil {
+		recipient.Email = *user.Email
+	}
+	if user.FirstName != nil {
+		recipient.Name = *user.FirstName
+	}
+	return recipient
+}
+
+// Data is what the templates are rendered with. Each message uses the parts it is
+// about; Name is filled in from the recipient.
+type Data struct {
+	Name     string
+	Order    Order
+	Shipment Shipment
+	Refund   Refund
+	Reason   string
+	Link     string
+}
+
+// Compose renders the message called name for to
+func Compose(to Recipient, name string, data Data) (mailer.Message, error) {
+	msg, ok := messages[to.Locale][name]
+	if !ok {
+		msg, ok = messages[DefaultLocale][name]
+	}
+	if !ok {
+		return mailer.Message{}, fmt.Errorf("no email template %q", name)
+	}
+	data.Name = to.Name
+
+	var subject, text, html bytes.Buffer
+	if err := msg.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return mailer.Message{}, err
+	}
+	if err := msg.text.ExecuteTemplate(&text, "text", data); err != nil {
+		return mailer.Message{}, err
+	}
+	if err := msg.html.ExecuteTemplate(&html, "layout", data); err != nil {
+		return mailer.Message{}, err
+	}
+	return mailer.Message{
+		To:      to.Email,
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    strings.TrimSpace(text.String()) + "\n",
+		HTML:    html.String(),
+		Tag:     name,
+		UserId:  to.UserId,
+	}, nil
+}
+
+// Order is the part of an order shown in emails, with amounts already formatted
+type Order struct {
+	Number         string
+	OrderedAt      time.Time
+	Lines          []Line
+	ItemsTotal     string
+	ShippingTotal  string
+	Total          string
+	CashOnDelivery bool
+}
+
+// Line is one line item of an order or shipment
+type Line struct {
+	Name     string
+	Quantity int
+	Amount   string
+}
+
+// NewOrder prepares order for the templates
+func NewOrder(order models.Order) Order {
+	currency := currencyOf(order)
+	view := Order{
+		Number:         order.OrderId.Hex(),
+		OrderedAt:      order.OrderedAt,
+		Lines:          make([]Line, 0, len(order.LineItems)),
+		ItemsTotal:     invoice.FormatAmount(order.ItemsTotal, currency),
+		ShippingTotal:  invoice.FormatAmount(order.ShippingTotal, currency),
+		Total:          invoice.FormatAmount(order.Price, currency),
+		CashOnDelivery: order.PaymentMethod.COD,
+	}
+	for _, line := range order.LineItems {
+		view.Lines = append(view.Lines, Line{
+			Name:     productName(line),
+			Quantity: line.Quantity,
+			Amount:   invoice.FormatAmount(line.LineTotal(), currency),
+		})
+	}
+	return view
+}
+
+// Shipment is a parcel as shown in emails
+type Shipment struct {
+	Carrier        string
+	TrackingNumber string
+	TrackingURL    string
+	Lines          []Line
+}
+
+// NewShipment prepares shipment of order for the templates
+func NewShipment(shipment models.Shipment, order models.Order) Shipment {
+	view := Shipment{
+		Carrier:        shipment.Carrier,
+		TrackingNumber: shipment.TrackingNumber,
+		TrackingURL:    shipment.TrackingURL,
+		Lines:          make([]Line, 0, len(shipment.Items)),
+	}
+	for _, item := range shipment.Items {
+		for _, line := range order.LineItems {
+			if line.LineId == item.LineId {
+				view.Lines = append(view.Lines, Line{Name: productName(line), Quantity: item.Quantity})
+			}
+		}
+	}
+	return view
+}
+
+// Refund is a refund as shown in emails
+type Refund struct {
+	Amount      string
+	StoreCredit bool
+}
+
+// NewRefund prepares a refund ledger entry for the templates
+func NewRefund(entry models.LedgerEntry) Refund {
+	return Refund{
+		Amount:      invoice.FormatAmount(entry.Amount, entry.Currency),
+		StoreCredit: entry.Method == models.RefundToStoreCredit,
+	}
+}
+
+func currencyOf(order models.Order) string {
+	if order.Charge != nil {
+		return order.Charge.Currency
+	}
+	return payment.Currency()
+}
+
+func productName(line models.LineItem) string {
+	if line.ProductName == nil {
+		return line.ProductId.Hex()
+	}
+	return *line.ProductName
+}
@@ -0,0 +1,254 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	testClientID     = "shop"
+	testClientSecret = "secret"
+	testRedirectURL  = "http://localhost:8000/users/oauth/mock/callback"
+	testCode         = "authorization-code"
+	testKid          = "mock-key"
+)
+
+// mockServer is a local OpenID Connect provider serving discovery, its JWKS and a token
+// endpoint that checks the PKCE verifier against the challenge of the sign in
+type mockServer struct {
+	*httptest.Server
+	key ed25519.PrivateKey
+
+	mu        sync.Mutex
+	challenge string
+	// idToken builds the ID token the token endpoint returns
+	idToken func(issuer string) string
+	// discoveredIssuer overrides the issuer in the discovery document when set
+	discoveredIssuer string
+}
+
+func newMockServer(t *testing.T) *mockServer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := &mockServer{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := mock.URL
+		if mock.discoveredIssuer != "" {
+			issuer = mock.discoveredIssuer
+		}
+		writeJSON(w, map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": mock.URL + "/authorize",
+			"token_endpoint":         mock.URL + "/token",
+			"jwks_uri":               mock.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		public := key.Public().(ed25519.PublicKey)
+		writeJSON(w, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"kid": testKid,
+			"x":   base64.RawURLEncoding.EncodeToString(public),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		clientID, secret, _ := r.BasicAuth()
+		mock.mu.Lock()
+		challenge := mock.challenge
+		mock.mu.Unlock()
+		switch {
+		case clientID != testClientID || secret != testClientSecret:
+			http.Error(w, "invalid_client", http.StatusUnauthorized)
+		case r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("code") != testCode:
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+		case r.PostForm.Get("redirect_uri") != testRedirectURL:
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+		case CodeChallenge(r.PostForm.Get("code_verifier")) != challenge:
+			http.Error(w, "invalid_grant", http.StatusBadRequest)
+		default:
+			writeJSON(w, map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": mock.idToken(mock.URL)})
+		}
+	})
+
+	mock.Server = httptest.NewServer(mux)
+	t.Cleanup(mock.Close)
+	return mock
+}
+
+func writeJSON(w http.ResponseWriter, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(body)
+}
+
+// sign issues an ID token with claims, signed by key under the mock's key id
+func sign(t *testing.T, key ed25519.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, claims)
+	token.Header["kid"] = testKid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func validClaims(issuer, nonce string) jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            issuer,
+		"aud":            testClientID,
+		"sub":            "user-1",
+		"nonce":          nonce,
+		"exp":            time.Now().Add(5 * time.Minute).Unix(),
+		"iat":            time.Now().Unix(),
+		"email":          "user@example.com",
+		"email_verified": true,
+		"given_name":     "Ada",
+		"family_name":    "Lovelace",
+	}
+}
+
+func (mock *mockServer) provider() *Provider {
+	return &Provider{
+		Name:         "mock",
+		Issuer:       mock.URL,
+		ClientID:     testClientID,
+		ClientSecret: testClientSecret,
+		RedirectURL:  testRedirectURL,
+		Scopes:       []string{"openid", "email", "profile"},
+		HTTPClient:   mock.Client(),
+	}
+}
+
+// signIn starts a sign in the way OAuthStart does and records the PKCE challenge the
+// browser would carry to the provider
+func (mock *mockServer) signIn(t *testing.T, provider *Provider, state, nonce, verifier string) url.Values {
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	mock.mu.Lock()
+	mock.challenge = query.Get("code_challenge")
+	mock.mu.Unlock()
+	return query
+}
+
+func TestExchange(t *testing.T) {
+	mock := newMockServer(t)
+	provider := mock.provider()
+	mock.idToken = func(issuer string) string { return sign(t, mock.key, validClaims(issuer, "nonce-1")) }
+
+	query := mock.signIn(t, provider, "state-1", "nonce-1", "verifier-1")
+	for key, want := range map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"state":                 "state-1",
+		"nonce":                 "nonce-1",
+		"code_challenge":        CodeChallenge("verifier-1"),
+		"code_challenge_method": "S256",
+	} {
+		if got := query.Get(key); got != want {
+			t.Errorf("authorization URL %s = %q, want %q", key, got, want)
+		}
+	}
+
+	claims, err := provider.Exchange(context.Background(), testCode, "verifier-1", "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Claims{Subject: "user-1", Email: "user@example.com", EmailVerified: true, GivenName: "Ada", FamilyName: "Lovelace"}
+	if *claims != want {
+		t.Errorf("claims = %+v, want %+v", *claims, want)
+	}
+}
+
+func TestExchangeForwardsPKCEVerifier(t *testing.T) {
+	mock := newMockServer(t)
+	provider := mock.provider()
+	mock.idToken = func(issuer string) string { return sign(t, mock.key, validClaims(issuer, "nonce-1")) }
+
+	mock.signIn(t, provider, "state-1", "nonce-1", "verifier-1")
+	_, err := provider.Exchange(context.Background(), testCode, "another-verifier", "nonce-1")
+	if !errors.Is(err, ErrTokenExchange) {
+		t.Errorf("exchange with the wrong verifier: err = %v, want %v", err, ErrTokenExchange)
+	}
+}
+
+func TestExchangeRejectsInvalidIDTokens(t *testing.T) {
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		key    func(mock *mockServer) ed25519.PrivateKey
+		change func(claims jwt.MapClaims)
+	}{
+		{name: "signed by another key", key: func(*mockServer) ed25519.PrivateKey { return otherKey }},
+		{name: "other audience", change: func(claims jwt.MapClaims) { claims["aud"] = "someone-else" }},
+		{name: "other issuer", change: func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example.com" }},
+		{name: "other nonce", change: func(claims jwt.MapClaims) { claims["nonce"] = "replayed" }},
+		{name: "expired", change: func(claims jwt.MapClaims) { claims["exp"] = time.Now().Add(-time.Minute).Unix() }},
+		{name: "no expiry", change: func(claims jwt.MapClaims) { delete(claims, "exp") }},
+		{name: "no subject", change: func(claims jwt.MapClaims) { delete(claims, "sub") }},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mock := newMockServer(t)
+			provider := mock.provider()
+			mock.idToken = func(issuer string) string {
+				claims := validClaims(issuer, "nonce-1")
+				if tc.change != nil {
+					tc.change(claims)
+				}
+				key := mock.key
+				if tc.key != nil {
+					key = tc.key(mock)
+				}
+				return sign(t, key, claims)
+			}
+
+			mock.signIn(t, provider, "state-1", "nonce-1", "verifier-1")
+			_, err := provider.Exchange(context.Background(), testCode, "verifier-1", "nonce-1")
+			if !errors.Is(err, ErrInvalidIDToken) {
+				t.Errorf("err = %v, want %v", err, ErrInvalidIDToken)
+			}
+		})
+	}
+}
+
+func TestDiscoveryRejectsOtherIssuer(t *testing.T) {
+	mock := newMockServer(t)
+	mock.discoveredIssuer = "https://evil.example.com"
+
+	_, err := mock.provider().AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if !errors.Is(err, ErrDiscovery) {
+		t.Errorf("err = %v, want %v", err, ErrDiscovery)
+	}
+}
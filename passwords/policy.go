@@ -0,0 +1,80 @@
+package passwords
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+)
+
+var ErrPasswordBreached = errors.New("this password has appeared in a data breach, please choose another one")
+
+//go:embed breached.txt
+var breachedList string
+
+// Policy is enforced whenever a user chooses a password
+type Policy struct {
+	MinLength int
+	MaxLength int
+	breached  map[string]struct{}
+}
+
+// DefaultPolicy reads PASSWORD_MIN_LENGTH and loads the embedded breached password list,
+// plus the file named by PASSWORD_BREACHED_LIST if set.
+var DefaultPolicy = policyFromEnv()
+
+func policyFromEnv() *Policy {
+	policy := &Policy{
+		MinLength: envInt("PASSWORD_MIN_LENGTH", 8),
+		// NIST SP 800-63B asks for at least 64 characters; the cap only keeps
+		// megabyte-sized request bodies from being copied and hashed
+		MaxLength: 128,
+		breached:  make(map[string]struct{}),
+	}
+	policy.addBreached(strings.NewReader(breachedList))
+
+	if path := os.Getenv("PASSWORD_BREACHED_LIST"); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			log.Printf("could not open PASSWORD_BREACHED_LIST: %v", err)
+			return policy
+		}
+		defer file.Close()
+		policy.addBreached(file)
+	}
+	return policy
+}
+
+func (p *Policy) addBreached(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		p.breached[strings.ToLower(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		log.Println(err)
+	}
+}
+
+// Check returns an error describing why password is not acceptable, or nil
+func (p *Policy) Check(password string) error {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return fmt.Errorf("password must be at least %d characters long", p.MinLength)
+	}
+	if length > p.MaxLength {
+		return fmt.Errorf("password must be at most %d characters long", p.MaxLength)
+	}
+	if _, found := p.breached[strings.ToLower(password)]; found {
+		return ErrPasswordBreached
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+// Package payment abstracts the payment provider orders are charged through. Amounts are
+// in the smallest unit of the store currency, like product prices.
+package payment
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrDeclined            = errors.New("payment was declined")
+	ErrUnknownTransaction  = errors.New("unknown payment transaction")
+	ErrAlreadyVoided       = errors.New("payment was already voided")
+	ErrRefundExceedsCharge = errors.New("refund exceeds the amount charged")
+	ErrUnknownProvider     = errors.New("unknown PAYMENT_PROVIDER")
+	ErrNoProvider          = errors.New("digital payments are not available, pay cash on delivery instead")
+)
+
+// ChargeRequest asks a provider to take a payment. Token is the payment method as
+// tokenized by the provider's client side library; card details never reach us.
+type ChargeRequest struct {
+	Amount    int
+	Currency  string
+	Reference string
+	Token     string
+}
+
+// Provider takes, voids and refunds payments
+type Provider interface {
+	Name() string
+	// Charge authorizes and captures the amount in one step and returns the transaction id
+	Charge(ctx context.Context, req ChargeRequest) (string, error)
+	// Void cancels a charge in full
+	Void(ctx context.Context, transactionId string) error
+	// Refund returns part or all of a charge and returns the refund id. Refunds with the
+	// same idempotency key are made only once; repeating one returns the first refund id.
+	Refund(ctx context.Context, transactionId string, amount int, idempotencyKey string) (string, error)
+}
+
+// Currency reads STORE_CURRENCY, the ISO 4217 code prices are in. It defaults to USD.
+func Currency() string {
+	if currency := strings.TrimSpace(os.Getenv("STORE_CURRENCY")); currency != "" {
+		return strings.ToUpper(currency)
+	}
+	return "USD"
+}
+
+// FromEnv returns the provider named by PAYMENT_PROVIDER, or nil when none is set, in
+// which case only cash on delivery is accepted. Only the sandbox provider is built in;
+// a real gateway implements Provider and is selected here. Any other name is an error
+// rather than taking orders without payment.
+func FromEnv() (Provider, error) {
+	name := strings.TrimSpace(os.Getenv("PAYMENT_PROVIDER"))
+	switch name {
+	case "":
+		return nil, nil
+	case "sandbox":
+		return NewSandbox(), nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownProvider, name)
+}
+
+// Sandbox approves every charge except those made with the token "decline". It keeps
+// its transactions in memory and is meant for development and tests.
+type Sandbox struct {
+	mu           sync.Mutex
+	transactions map[string]*sandboxTransaction
+}
+
+type sandboxTransaction struct {
+	amount   int
+	refunded int
+	voided   bool
+	// refunds maps idempotency keys to refund ids
+	refunds map[string]string
+}
+
+func NewSandbox() *Sandbox {
+	return &Sandbox{transactions: make(map[string]*sandboxTransaction)}
+}
+
+func (sandbox *Sandbox) Name() string {
+	return "sandbox"
+}
+
+func (sandbox *Sandbox) Charge(ctx context.Context, req ChargeRequest) (string, error) {
+	if req.Token == "decline" || req.Amount <= 0 {
+		return "", ErrDeclined
+	}
+	transactionId, err := newId("ch_")
+	if err != nil {
+		return "", err
+	}
+
+	sandbox.mu.Lock()
+	defer sandbox.mu.Unlock()
+	sandbox.transactions[transactionId] = &sandboxTransaction{amount: req.Amount, refunds: make(map[string]string)}
+	return transactionId, nil
+}
+
+func (sandbox *Sandbox) Void(ctx context.Context, transactionId string) error {
+	sandbox.mu.Lock()
+	defer sandbox.mu.Unlock()
+
+	transaction, ok := sandbox.transactions[transactionId]
+	if !ok {
+		return ErrUnknownTransaction
+	}
+	if transaction.voided {
+		return ErrAlreadyVoided
+	}
+	transaction.voided = true
+	return nil
+}
+
+func (sandbox *Sandbox) Refund(ctx context.Context, transactionId string, amount int, idempotencyKey string) (string, error) {
+	sandbox.mu.Lock()
+	defer sandbox.mu.Unlock()
+
+	transaction, ok := sandbox.transactions[transactionId]
+	if !ok {
+		return "", ErrUnknownTransaction
+	}
+	if refundId, ok := transaction.refunds[idempotencyKey]; ok {
+		return refundId, nil
+	}
+	if transaction.voided {
+		return "", ErrAlreadyVoided
+	}
+	if amount <= 0 || transaction.refunded+amount > transaction.amount {
+		return "", ErrRefundExceedsCharge
+	}
+	refundId, err := newId("re_")
+	if err != nil {
+		return "", err
+	}
+	transaction.refunded += amount
+	transaction.refunds[idempotencyKey] = refundId
+	return refundId, nil
+}
+
+func newId(prefix string) (string, error) {
+	raw := make([]byte, 12)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return prefix + hex.EncodeToString(raw), nil
+}
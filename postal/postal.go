@@ -0,0 +1,231 @@
+// Package postal validates and normalizes addresses against per-country schemas. The
+// schemas live in countries/<ISO code>.json and are compiled into the binary; supporting
+// another country means adding a file there.
+package postal
+
+import (
+	"embed"
+	"encoding/json"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go-ecommerce/models"
+)
+
+//go:embed countries/*.json
+var countryFiles embed.FS
+
+// Names of the address fields as they appear in schemas and field errors
+const (
+	FieldCountry = "country"
+	FieldHouse   = "house"
+	FieldStreet  = "street"
+	FieldCity    = "city"
+	FieldRegion  = "region"
+	FieldPinCode = "pinCode"
+)
+
+// Schema describes what a deliverable address looks like in one country
+type Schema struct {
+	Code     string            `json:"code"`
+	Name     string            `json:"name"`
+	Required []string          `json:"required"`
+	Casing   map[string]string `json:"casing"`
+	// Regions, when present, lists the only accepted region codes
+	Regions []string `json:"regions"`
+	// PostalCodes are tried in order against the postal code stripped of spaces and
+	// dashes; the first match is rewritten with its format
+	PostalCodes []struct {
+		Match  string `json:"match"`
+		Format string `json:"format"`
+		re     *regexp.Regexp
+	} `json:"postalCodes"`
+	PostalCodeExample string `json:"postalCodeExample"`
+}
+
+var schemas = mustLoadSchemas()
+
+func mustLoadSchemas() map[string]*Schema {
+	paths, err := countryFiles.ReadDir("countries")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	loaded := make(map[string]*Schema, len(paths))
+	for _, entry := range paths {
+		raw, err := countryFiles.ReadFile(path.Join("countries", entry.Name()))
+		if err != nil {
+			log.Fatal(err)
+		}
+		var schema Schema
+		if err := json.Unmarshal(raw, &schema); err != nil {
+			log.Fatalf("%s: %v", entry.Name(), err)
+		}
+		for i := range schema.PostalCodes {
+			schema.PostalCodes[i].re = regexp.MustCompile(schema.PostalCodes[i].Match)
+		}
+		loaded[schema.Code] = &schema
+	}
+	return loaded
+}
+
+// Lookup returns the schema of the country with the given ISO 3166-1 alpha-2 code
+func Lookup(country string) (*Schema, bool) {
+	schema, ok := schemas[strings.ToUpper(strings.TrimSpace(country))]
+	return schema, ok
+}
+
+// Countries lists the codes of every supported country
+func Countries() []string {
+	codes := make([]string, 0, len(schemas))
+	for code := range schemas {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// FieldError is a problem with one field of an address
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationError lists every problem found with an address
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (err *ValidationError) Error() string {
+	messages := make([]string, 0, len(err.Fields))
+	for _, field := range err.Fields {
+		messages = append(messages, field.Field+": "+field.Message)
+	}
+	return "address is not deliverable: " + strings.Join(messages, "; ")
+}
+
+// Normalize returns address cleaned up for its country: whitespace trimmed and
+// collapsed, fields cased as the country expects and the postal code in its canonical
+// format. When the address cannot be delivered to, the error is a *ValidationError.
+func Normalize(address models.Address) (models.Address, error) {
+	invalid := &ValidationError{}
+
+	country := clean(address.Country)
+	schema, ok := Lookup(country)
+	if !ok {
+		if country == "" {
+			invalid.add(FieldCountry, "is required")
+		} else {
+			invalid.add(FieldCountry, "is not a country we deliver to")
+		}
+		return address, invalid
+	}
+	code := schema.Code
+	address.Country = &code
+
+	fields := map[string]**string{
+		FieldHouse:   &address.House,
+		FieldStreet:  &address.Street,
+		FieldCity:    &address.City,
+		FieldRegion:  &address.Region,
+		FieldPinCode: &address.PinCode,
+	}
+	for name, field := range fields {
+		value := applyCasing(clean(*field), schema.Casing[name])
+		if value == "" {
+			*field = nil
+			continue
+		}
+		*field = &value
+	}
+	address.Label = trimmed(address.Label)
+
+	for _, name := range schema.Required {
+		if *fields[name] == nil {
+			invalid.add(name, "is required in "+schema.Name)
+		}
+	}
+
+	if address.Region != nil && len(schema.Regions) > 0 && !contains(schema.Regions, *address.Region) {
+		invalid.add(FieldRegion, "is not a region of "+schema.Name)
+	}
+
+	if address.PinCode != nil {
+		formatted, ok := schema.formatPostalCode(*address.PinCode)
+		if ok {
+			address.PinCode = &formatted
+		} else {
+			invalid.add(FieldPinCode, "is not a valid postal code in "+schema.Name+", e.g. "+schema.PostalCodeExample)
+		}
+	}
+
+	if len(invalid.Fields) > 0 {
+		sort.Slice(invalid.Fields, func(i, j int) bool { return invalid.Fields[i].Field < invalid.Fields[j].Field })
+		return address, invalid
+	}
+	return address, nil
+}
+
+func (schema *Schema) formatPostalCode(value string) (string, bool) {
+	compact := strings.ToUpper(strings.NewReplacer(" ", "", "-", "").Replace(value))
+	for _, postalCode := range schema.PostalCodes {
+		if postalCode.re.MatchString(compact) {
+			return postalCode.re.ReplaceAllString(compact, postalCode.Format), true
+		}
+	}
+	return "", false
+}
+
+func (invalid *ValidationError) add(field, message string) {
+	invalid.Fields = append(invalid.Fields, FieldError{Field: field, Message: message})
+}
+
+// clean trims value and collapses runs of whitespace to single spaces
+func clean(value *string) string {
+	if value == nil {
+		return ""
+	}
+	return strings.Join(strings.Fields(*value), " ")
+}
+
+func trimmed(value *string) *string {
+	if value == nil {
+		return nil
+	}
+	cleaned := clean(value)
+	return &cleaned
+}
+
+// applyCasing upper-cases or title-cases value. Title casing leaves mixed case input
+// alone, so names like "McAllen" keep the casing the user gave them.
+func applyCasing(value, casing string) string {
+	switch casing {
+	case "upper":
+		return strings.ToUpper(value)
+	case "title":
+		if value != strings.ToLower(value) && value != strings.ToUpper(value) {
+			return value
+		}
+		words := strings.Fields(strings.ToLower(value))
+		for i, word := range words {
+			runes := []rune(word)
+			runes[0] = unicode.ToUpper(runes[0])
+			words[i] = string(runes)
+		}
+		return strings.Join(words, " ")
+	}
+	return value
+}
+
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package postal
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go-ecommerce/models"
+)
+
+func str(value string) *string {
+	return &value
+}
+
+func value(field *string) string {
+	if field == nil {
+		return "<nil>"
+	}
+	return *field
+}
+
+// fieldsOf returns the fields named in err, which must be a *ValidationError
+func fieldsOf(t *testing.T, err error) []string {
+	t.Helper()
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("error %v is not a *ValidationError", err)
+	}
+	fields := make([]string, 0, len(invalid.Fields))
+	for _, field := range invalid.Fields {
+		fields = append(fields, field.Field)
+	}
+	return fields
+}
+
+// TestPostalCodes checks the postal code patterns and formats of every country file
+func TestPostalCodes(t *testing.T) {
+	cases := []struct {
+		country string
+		input   string
+		want    string
+		ok      bool
+	}{
+		{"GB", "sw1a1aa", "SW1A 1AA", true},
+		{"GB", "SW1A 1AA", "SW1A 1AA", true},
+		{"GB", "m11ae", "M1 1AE", true},
+		{"GB", "ec1a 1bb", "EC1A 1BB", true},
+		{"GB", "1AA", "", false},
+		{"NL", "1012js", "1012 JS", true},
+		{"NL", "1012 JS", "1012 JS", true},
+		{"NL", "0123AB", "", false},
+		{"NL", "1012", "", false},
+		{"US", "94105", "94105", true},
+		{"US", "941051234", "94105-1234", true},
+		{"US", "94105-1234", "94105-1234", true},
+		{"US", "94105 1234", "94105-1234", true},
+		{"US", "9410", "", false},
+		{"US", "94105-123", "", false},
+		{"CA", "k1a0b1", "K1A 0B1", true},
+		{"CA", "K1A-0B1", "K1A 0B1", true},
+		{"CA", "D1A0B1", "", false},
+		{"CA", "K1A0BO", "", false},
+		{"DE", "10115", "10115", true},
+		{"DE", "1011", "", false},
+		{"FR", "75008", "75008", true},
+		{"FR", "7500A", "", false},
+		{"IN", "110001", "110001", true},
+		{"IN", "010001", "", false},
+		{"NG", "100001", "100001", true},
+		{"NG", "10001", "", false},
+	}
+
+	for _, c := range cases {
+		schema, ok := Lookup(c.country)
+		if !ok {
+			t.Fatalf("no schema for %s", c.country)
+		}
+		got, ok := schema.formatPostalCode(c.input)
+		if got != c.want || ok != c.ok {
+			t.Errorf("%s formatPostalCode(%q) = %q, %v, want %q, %v", c.country, c.input, got, ok, c.want, c.ok)
+		}
+	}
+}
+
+// TestPostalCodeExamples checks that the example of every country is a valid postal
+// code in its canonical format, since it is shown to users who enter a wrong one
+func TestPostalCodeExamples(t *testing.T) {
+	for _, code := range Countries() {
+		schema, _ := Lookup(code)
+		for _, example := range strings.Split(schema.PostalCodeExample, " or ") {
+			if got, ok := schema.formatPostalCode(example); !ok || got != example {
+				t.Errorf("%s example %q formats as %q, %v", code, example, got, ok)
+			}
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	address := models.Address{
+		Label:   str("  home "),
+		House:   str(" 10 "),
+		Street:  str("downing   street"),
+		City:    str("london"),
+		PinCode: str("sw1a1aa"),
+		Country: str(" gb "),
+	}
+
+	got, err := Normalize(address)
+	if err != nil {
+		t.Fatalf("Normalize: %v", err)
+	}
+	want := map[string]string{
+		"label":   "home",
+		"house":   "10",
+		"street":  "downing street",
+		"city":    "LONDON",
+		"region":  "<nil>",
+		"pinCode": "SW1A 1AA",
+		"country": "GB",
+	}
+	gotFields := map[string]string{
+		"label":   value(got.Label),
+		"house":   value(got.House),
+		"street":  value(got.Street),
+		"city":    value(got.City),
+		"region":  value(got.Region),
+		"pinCode": value(got.PinCode),
+		"country": value(got.Country),
+	}
+	if !reflect.DeepEqual(gotFields, want) {
+		t.Errorf("Normalize = %v, want %v", gotFields, want)
+	}
+}
+
+func TestNormalizeRequiredFields(t *testing.T) {
+	_, err := Normalize(models.Address{Country: str("US"), Street: str("   ")})
+	want := []string{FieldCity, FieldHouse, FieldPinCode, FieldRegion, FieldStreet}
+	if got := fieldsOf(t, err); !reflect.DeepEqual(got, want) {
+		t.Errorf("missing fields = %v, want %v", got, want)
+	}
+
+	// Nigeria does not require a postal code
+	_, err = Normalize(models.Address{Country: str("NG"), House: str("1"), Street: str("Broad Street"), City: str("lagos"), Region: str("lagos")})
+	if err != nil {
+		t.Errorf("Normalize without a Nigerian postal code: %v", err)
+	}
+}
+
+func TestNormalizeInvalidFields(t *testing.T) {
+	address := models.Address{
+		House:   str("1600"),
+		Street:  str("Pennsylvania Avenue NW"),
+		City:    str("washington"),
+		Region:  str("xx"),
+		PinCode: str("2050"),
+		Country: str("US"),
+	}
+	_, err := Normalize(address)
+	want := []string{FieldPinCode, FieldRegion}
+	if got := fieldsOf(t, err); !reflect.DeepEqual(got, want) {
+		t.Errorf("invalid fields = %v, want %v", got, want)
+	}
+	if !strings.Contains(err.Error(), "e.g. 94105 or 94105-1234") {
+		t.Errorf("error %q does not show the example postal code", err)
+	}
+
+	// regions are matched after casing
+	address.Region, address.PinCode = str("dc"), str("20500")
+	got, err := Normalize(address)
+	if err != nil {
+		t.Fatalf("Normalize: %v", err)
+	}
+	if value(got.Region) != "DC" {
+		t.Errorf("region = %s, want DC", value(got.Region))
+	}
+}
+
+func TestNormalizeUnknownCountry(t *testing.T) {
+	cases := []struct {
+		country *string
+		message string
+	}{
+		{str("ZZ"), "is not a country we deliver to"},
+		{str("  "), "is required"},
+		{nil, "is required"},
+	}
+
+	for _, c := range cases {
+		_, err := Normalize(models.Address{Country: c.country, City: str("Somewhere")})
+		var invalid *ValidationError
+		if !errors.As(err, &invalid) || len(invalid.Fields) != 1 {
+			t.Fatalf("Normalize(country %q) = %v, want one field error", value(c.country), err)
+		}
+		if field := invalid.Fields[0]; field.Field != FieldCountry || field.Message != c.message {
+			t.Errorf("Normalize(country %q) = %s %s, want country %s", value(c.country), field.Field, field.Message, c.message)
+		}
+	}
+}
+
+func TestApplyCasing(t *testing.T) {
+	cases := []struct {
+		value  string
+		casing string
+		want   string
+	}{
+		{"new york", "title", "New York"},
+		{"NEW YORK", "title", "New York"},
+		{"McAllen", "title", "McAllen"},
+		{"van Buren", "title", "van Buren"},
+		{"münchen", "title", "München"},
+		{"london", "upper", "LONDON"},
+		{"London", "", "London"},
+		{"", "title", ""},
+	}
+
+	for _, c := range cases {
+		if got := applyCasing(c.value, c.casing); got != c.want {
+			t.Errorf("applyCasing(%q, %q) = %q, want %q", c.value, c.casing, got, c.want)
+		}
+	}
+}
+
+// TestNormalizeKeepsMixedCase checks title casing through Normalize, where a city the
+// user typed in mixed case must come out unchanged
+func TestNormalizeKeepsMixedCase(t *testing.T) {
+	address := models.Address{
+		House:   str("1"),
+		Street:  str("Main Street"),
+		City:    str("McAllen"),
+		Region:  str("tx"),
+		PinCode: str("78501"),
+		Country: str("us"),
+	}
+	got, err := Normalize(address)
+	if err != nil {
+		t.Fatalf("Normalize: %v", err)
+	}
+	if value(got.City) != "McAllen" {
+		t.Errorf("city = %s, want McAllen", value(got.City))
+	}
+}
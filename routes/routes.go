@@ -2,6 +2,8 @@ package routes
 
 import (
 	"go-ecommerce/controllers"
+	"go-ecommerce/middleware"
+	"go-ecommerce/models"
 
 	"github.com/gin-gonic/gin"
 )
@@ -9,7 +11,90 @@ import (
 func UserRoutes(incomingRoutes *gin.Engine) {
 	incomingRoutes.POST("/users/signup", controllers.Signup())
 	incomingRoutes.POST("/users/login", controllers.Login())
-	incomingRoutes.POST("/admin/addproduct", controllers.AddProductAdmin())
+	incomingRoutes.POST("/users/login/mfa", controllers.LoginMFA())
+	incomingRoutes.POST("/users/login/magic", controllers.RequestMagicLink())
+	incomingRoutes.GET("/users/login/magic/verify", controllers.ConfirmMagicLink())
+	incomingRoutes.POST("/users/login/magic/verify", controllers.RedeemMagicLink())
+	incomingRoutes.GET("/users/oauth/:provider/start", controllers.OAuthStart())
+	incomingRoutes.GET("/users/oauth/:provider/callback", controllers.OAuthCallback())
+	incomingRoutes.POST("/users/logout", middleware.SessionAuthentication(), controllers.Logout())
+	incomingRoutes.POST("/users/token/refresh", controllers.RefreshToken())
+	incomingRoutes.GET("/users/me", middleware.SessionAuthentication(), controllers.GetProfile())
+	incomingRoutes.PATCH("/users/me", middleware.SessionAuthentication(), controllers.UpdateProfile())
+	incomingRoutes.DELETE("/users/me", middleware.SessionAuthentication(), controllers.DeleteAccount())
+	incomingRoutes.DELETE("/users/me/deletion", middleware.SessionAuthentication(), controllers.CancelAccountDeletion())
+	incomingRoutes.GET("/users/me/export", middleware.SessionAuthentication(), controllers.ExportData())
+	incomingRoutes.POST("/users/me/password", middleware.SessionAuthentication(), controllers.ChangePassword())
+	incomingRoutes.POST("/users/me/email", middleware.SessionAuthentication(), controllers.ChangeEmail())
+	incomingRoutes.GET("/users/me/email/confirm", controllers.ConfirmEmailChange())
+	incomingRoutes.POST("/users/me/email/confirm", controllers.CompleteEmailChange())
+	incomingRoutes.GET("/users/sessions", middleware.SessionAuthentication(), controllers.ListSessions())
+	incomingRoutes.DELETE("/users/sessions/:id", middleware.SessionAuthentication(), controllers.RevokeSession())
+	incomingRoutes.POST("/users/password/forgot", controllers.ForgotPassword())
+	incomingRoutes.POST("/users/password/reset", controllers.ResetPassword())
+	incomingRoutes.GET("/users/verify", controllers.ConfirmVerification())
+	incomingRoutes.POST("/users/verify", controllers.VerifyEmail())
+	incomingRoutes.POST("/users/verify/resend", middleware.SessionAuthentication(), controllers.ResendVerification())
+	incomingRoutes.POST("/users/mfa/totp/enroll", middleware.SessionAuthentication(), controllers.EnrollTOTP())
+	incomingRoutes.POST("/users/mfa/totp/verify", middleware.SessionAuthentication(), controllers.VerifyTOTP())
 	incomingRoutes.GET("/users/productview", controllers.SearchProduct())
 	incomingRoutes.GET("/users/search", controllers.SearchProductByQuery())
+	incomingRoutes.GET("/.well-known/jwks.json", controllers.JWKS())
+}
+
+// AddressRoutes registers the address book of the authenticated user
+func AddressRoutes(incomingRoutes *gin.Engine) {
+	addresses := incomingRoutes.Group("/addresses", middleware.SessionAuthentication())
+	addresses.GET("", controllers.ListAddresses())
+	addresses.POST("", controllers.AddAddress())
+	addresses.GET("/:addressId", controllers.GetAddress())
+	addresses.PUT("/:addressId", controllers.UpdateAddress())
+	addresses.DELETE("/:addressId", controllers.DeleteAddress())
+}
+
+// OrderRoutes registers checkout and the order history of the authenticated user
+func OrderRoutes(incomingRoutes *gin.Engine, app *controllers.Application) {
+	orders := incomingRoutes.Group("/orders", middleware.SessionAuthentication())
+	orders.GET("", app.ListOrders())
+	orders.POST("", app.Checkout())
+	orders.GET("/:orderId", app.GetOrder())
+	orders.GET("/:orderId/shipments", app.ListShipments())
+	orders.POST("/:orderId/cancel", app.CancelOrder())
+	orders.GET("/:orderId/returns", app.ListOrderReturns())
+	orders.POST("/:orderId/returns", app.RequestReturn())
+	orders.GET("/:orderId/ledger", app.ListLedger())
+	orders.GET("/:orderId/invoice", app.GetInvoice())
+}
+
+// AdminRoutes registers the /admin group. Every route in it requires a valid token and
+// a role granting the permission the route needs.
+func AdminRoutes(incomingRoutes *gin.Engine, app *controllers.Application) {
+	admin := incomingRoutes.Group("/admin", middleware.Authentication())
+	admin.POST("/addproduct", middleware.RequirePermission(models.PermManageProducts), controllers.AddProductAdmin())
+	admin.PUT("/users/:userId/role", middleware.RequirePermission(models.PermManageUsers), controllers.SetUserRole())
+	admin.DELETE("/users/:userId", middleware.RequirePermission(models.PermManageUsers), controllers.AdminEraseUser())
+	admin.DELETE("/users/:userId/deletion", middleware.RequirePermission(models.PermManageUsers), controllers.AdminCancelDeletion())
+	admin.POST("/orders/:orderId/shipments", middleware.RequirePermission(models.PermManageOrders), app.CreateShipment())
+	admin.POST("/shipments/:shipmentId/events", middleware.RequirePermission(models.PermManageOrders), app.AddShipmentEvent())
+	admin.POST("/orders/:orderId/refunds", middleware.RequirePermission(models.PermManageOrders), app.RefundOrder())
+	admin.GET("/returns", middleware.RequirePermission(models.PermManageOrders), controllers.ListReturns())
+	admin.POST("/returns/:returnId/approve", middleware.RequirePermission(models.PermManageOrders), app.ReviewReturn(true))
+	admin.POST("/returns/:returnId/reject", middleware.RequirePermission(models.PermManageOrders), app.ReviewReturn(false))
+	admin.POST("/returns/:returnId/receive", middleware.RequirePermission(models.PermManageOrders), app.ReceiveReturn())
+
+	// cart and order operations on behalf of the user named in the path, all audited
+	onBehalf := admin.Group("/users/:userId", middleware.RequirePermission(models.PermManageCarts))
+	onBehalf.GET("/addtocart", app.AddToCart())
+	onBehalf.GET("/removeitem", app.RemoveItem())
+	onBehalf.GET("/listcart", app.GetItemFromCart())
+	onBehalf.GET("/cartcheckout", app.BuyFromCart())
+	onBehalf.GET("/instantbuy", app.InstantBuy())
+	onBehalf.GET("/orders", app.ListOrders())
+	onBehalf.GET("/orders/:orderId", app.GetOrder())
+	onBehalf.GET("/orders/:orderId/shipments", app.ListShipments())
+	onBehalf.POST("/orders/:orderId/cancel", app.CancelOrder())
+	onBehalf.GET("/orders/:orderId/returns", app.ListOrderReturns())
+	onBehalf.POST("/orders/:orderId/returns", app.RequestReturn())
+	onBehalf.GET("/orders/:orderId/ledger", app.ListLedger())
+	onBehalf.GET("/orders/:orderId/invoice", app.GetInvoice())
 }
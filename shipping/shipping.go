@@ -0,0 +1,78 @@
+// Package shipping quotes the cost of sending order line items to an address
+package shipping
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"go-ecommerce/models"
+)
+
+var ErrNothingToShip = errors.New("nothing to ship")
+
+// Quoter prices one shipment
+type Quoter interface {
+	Quote(ctx context.Context, address models.Address, items []models.LineItem) (models.ShippingQuote, error)
+}
+
+//go:embed rates.json
+var defaultRates []byte
+
+// rate is a base price per shipment plus a price per item
+type rate struct {
+	Service       string `json:"service"`
+	Base          int    `json:"base"`
+	PerItem       int    `json:"perItem"`
+	EstimatedDays int    `json:"estimatedDays"`
+}
+
+// TableRates quotes from a rate table: one rate within the origin country, overrides
+// for specific countries and one rate for everywhere else
+type TableRates struct {
+	Origin        string          `json:"origin"`
+	Carrier       string          `json:"carrier"`
+	Domestic      rate            `json:"domestic"`
+	International rate            `json:"international"`
+	Countries     map[string]rate `json:"countries"`
+}
+
+// DefaultRates returns the rate table bundled in rates.json
+func DefaultRates() *TableRates {
+	var table TableRates
+	if err := json.Unmarshal(defaultRates, &table); err != nil {
+		log.Fatal(err)
+	}
+	return &table
+}
+
+func (table *TableRates) Quote(ctx context.Context, address models.Address, items []models.LineItem) (models.ShippingQuote, error) {
+	quantity := 0
+	for _, item := range items {
+		quantity += item.Quantity
+	}
+	if quantity == 0 {
+		return models.ShippingQuote{}, ErrNothingToShip
+	}
+
+	country := ""
+	if address.Country != nil {
+		country = *address.Country
+	}
+	applied, ok := table.Countries[country]
+	if !ok {
+		applied = table.International
+		if country == table.Origin {
+			applied = table.Domestic
+		}
+	}
+
+	return models.ShippingQuote{
+		Carrier:       table.Carrier,
+		Service:       applied.Service,
+		Amount:        applied.Base + applied.PerItem*quantity,
+		EstimatedDays: applied.EstimatedDays,
+	}, nil
+}
@@ -0,0 +1,339 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrUnknownKey        = errors.New("token signed with an unknown key")
+	ErrUnexpectedMethod  = errors.New("unexpected signing method")
+	ErrUnsupportedKey    = errors.New("unsupported signing key type")
+	ErrNoSigningKey      = errors.New("no signing key available")
+	ErrInvalidSigningAlg = errors.New("JWT_SIGNING_ALG must be EdDSA or RS256")
+)
+
+// signingKey is one entry of the key ring. Only the newest key signs; older keys keep
+// verifying until every token they could have signed has expired.
+type signingKey struct {
+	kid       string
+	method    jwt.SigningMethod
+	private   crypto.Signer
+	createdAt time.Time
+}
+
+// keyRing holds the keys tokens are signed and verified with
+type keyRing struct {
+	mu   sync.RWMutex
+	dir  string
+	alg  string
+	keys []*signingKey // oldest first
+}
+
+var keys = mustLoadKeyRing()
+
+// mustLoadKeyRing loads every PEM encoded PKCS#8 private key in JWT_KEYS_DIR. When the
+// directory is unset or empty a fresh key is generated; it is written to the directory
+// if one is configured so that restarts and other instances pick it up.
+func mustLoadKeyRing() *keyRing {
+	ring := &keyRing{dir: os.Getenv("JWT_KEYS_DIR"), alg: os.Getenv("JWT_SIGNING_ALG")}
+	if ring.alg == "" {
+		ring.alg = jwt.SigningMethodEdDSA.Alg()
+	}
+	if ring.alg != jwt.SigningMethodEdDSA.Alg() && ring.alg != jwt.SigningMethodRS256.Alg() {
+		log.Fatal(ErrInvalidSigningAlg)
+	}
+
+	if ring.dir != "" {
+		if err := ring.load(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(ring.keys) == 0 {
+		if ring.dir == "" {
+			log.Println("JWT_KEYS_DIR is not set, signing tokens with an ephemeral key")
+		}
+		if err := ring.rotate(); err != nil {
+			log.Fatal(err)
+		}
+	}
+	return ring
+}
+
+func (ring *keyRing) load() error {
+	paths, err := filepath.Glob(filepath.Join(ring.dir, "*.pem"))
+	if err != nil {
+		return err
+	}
+
+	loaded := make([]*signingKey, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		block, _ := pem.Decode(raw)
+		if block == nil {
+			log.Printf("skipping %s: not PEM encoded", path)
+			continue
+		}
+		parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return err
+		}
+		key, err := newSigningKey(parsed, info.ModTime())
+		if err != nil {
+			return err
+		}
+		loaded = append(loaded, key)
+	}
+	sort.Slice(loaded, func(i, j int) bool { return loaded[i].createdAt.Before(loaded[j].createdAt) })
+
+	ring.mu.Lock()
+	ring.keys = loaded
+	ring.mu.Unlock()
+	return nil
+}
+
+func newSigningKey(private interface{}, createdAt time.Time) (*signingKey, error) {
+	key := &signingKey{createdAt: createdAt}
+	var publicDER []byte
+	var err error
+
+	switch private := private.(type) {
+	case ed25519.PrivateKey:
+		key.method, key.private = jwt.SigningMethodEdDSA, private
+		publicDER, err = x509.MarshalPKIXPublicKey(private.Public())
+	case *rsa.PrivateKey:
+		key.method, key.private = jwt.SigningMethodRS256, private
+		publicDER, err = x509.MarshalPKIXPublicKey(private.Public())
+	default:
+		return nil, ErrUnsupportedKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// the key id is a thumbprint of the public key so every instance derives the same one
+	sum := sha256.Sum256(publicDER)
+	key.kid = base64.RawURLEncoding.EncodeToString(sum[:12])
+	return key, nil
+}
+
+// rotate generates a new signing key and makes it the active one
+func (ring *keyRing) rotate() error {
+	var private interface{}
+	var err error
+	if ring.alg == jwt.SigningMethodRS256.Alg() {
+		private, err = rsa.GenerateKey(rand.Reader, 2048)
+	} else {
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return err
+	}
+
+	key, err := newSigningKey(private, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if ring.dir != "" {
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			return err
+		}
+		path := filepath.Join(ring.dir, key.kid+".pem")
+		if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+			return err
+		}
+	}
+
+	ring.mu.Lock()
+	ring.keys = append(ring.keys, key)
+	ring.mu.Unlock()
+	return nil
+}
+
+// prune drops keys that can no longer have signed a live token
+func (ring *keyRing) prune(retention time.Duration) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+
+	cutoff := time.Now().Add(-retention)
+	kept := ring.keys[:0]
+	for i, key := range ring.keys {
+		// the active key is always kept
+		if key.createdAt.After(cutoff) || i == len(ring.keys)-1 {
+			kept = append(kept, key)
+			continue
+		}
+		if ring.dir != "" {
+			if err := os.Remove(filepath.Join(ring.dir, key.kid+".pem")); err != nil && !os.IsNotExist(err) {
+				log.Println(err)
+			}
+		}
+	}
+	ring.keys = kept
+}
+
+func (ring *keyRing) active() (*signingKey, error) {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	if len(ring.keys) == 0 {
+		return nil, ErrNoSigningKey
+	}
+	return ring.keys[len(ring.keys)-1], nil
+}
+
+func (ring *keyRing) lookup(kid string) *signingKey {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	for _, key := range ring.keys {
+		if key.kid == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// sign signs claims with the active key and stamps its kid in the header
+func (ring *keyRing) sign(claims jwt.Claims) (string, error) {
+	key, err := ring.active()
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// verificationKey is the jwt.Keyfunc used during validation. The algorithm is pinned to
+// the one the referenced key was created for, so a token can never choose how it is checked.
+func (ring *keyRing) verificationKey(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+	key := ring.lookup(kid)
+	if key == nil {
+		return nil, ErrUnknownKey
+	}
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, ErrUnexpectedMethod
+	}
+	return key.private.Public(), nil
+}
+
+// StartKeyRotation generates a new signing key every interval, reloading JWT_KEYS_DIR
+// first so keys written by other instances are honoured. A zero interval disables rotation.
+func StartKeyRotation(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if keys.dir != "" {
+				if err := keys.load(); err != nil {
+					log.Println(err)
+				}
+			}
+			if err := keys.rotate(); err != nil {
+				log.Println(err)
+				continue
+			}
+			// a retired key must outlive every token it signed
+			keys.prune(interval + maxTokenLifetime)
+		}
+	}()
+}
+
+// JWK is the public part of a signing key as published in the JWKS document
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKS is served at /.well-known/jwks.json so other services can verify our tokens
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// PublicJWKS returns every key that may still verify a live token, newest first
+func PublicJWKS() JWKS {
+	keys.mu.RLock()
+	defer keys.mu.RUnlock()
+
+	set := JWKS{Keys: make([]JWK, 0, len(keys.keys))}
+	for i := len(keys.keys) - 1; i >= 0; i-- {
+		key := keys.keys[i]
+		jwk := JWK{Kid: key.kid, Use: "sig", Alg: key.method.Alg()}
+		switch public := key.private.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.Kty, jwk.Crv = "OKP", "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+// validMethods lists the algorithms of the keys currently in the ring
+func (ring *keyRing) validMethods() []string {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	methods := make([]string, 0, 2)
+	for _, key := range ring.keys {
+		if alg := key.method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	return methods
+}
+
+// RotationInterval reads JWT_ROTATION_INTERVAL, e.g. "720h". Rotation is off when unset.
+func RotationInterval() time.Duration {
+	value := strings.TrimSpace(os.Getenv("JWT_ROTATION_INTERVAL"))
+	if value == "" {
+		return 0
+	}
+	interval, err := time.ParseDuration(value)
+	if err != nil {
+		log.Printf("invalid JWT_ROTATION_INTERVAL %q: %v", value, err)
+		return 0
+	}
+	return interval
+}
@@ -1,55 +1,104 @@
 package token
 
 import (
-	"context"
-	"go-ecommerce/database"
-	"log"
+	"crypto/rand"
+	"encoding/base64"
 	"os"
 	"time"
 
-	jwt "github.com/dgrijalva/jwt-go"
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
-	"go.mongodb.org/mongo-driver/mongo/options"
+	jwt "github.com/golang-jwt/jwt/v4"
 )
 
-//claims are values you use to generate the token
+// claims are values you use to generate the token
 type SignedDetails struct {
 	Email     string
 	FirstName string
 	LastName  string
 	Uid       string
+	Role      string
+	TokenType string
+	SessionId string
 	jwt.StandardClaims
 }
 
-var UserData *mongo.Collection = database.UserData(database.Client, "Users")
+// Token types carried in the TokenType claim. Validation always names the type it
+// expects so that, for example, a refresh token can never be used as an access token.
+const (
+	AccessToken       = "access"
+	RefreshToken      = "refresh"
+	MFAChallengeToken = "mfa_challenge"
+	MagicLinkToken    = "magic_link"
+)
+
+const (
+	AccessTokenLifetime  = 24 * time.Hour
+	RefreshTokenLifetime = 7 * 24 * time.Hour
+	MagicLinkLifetime    = 15 * time.Minute
+	mfaChallengeLifetime = 5 * time.Minute
+	// maxTokenLifetime is how long a retired signing key must stay verifiable
+	maxTokenLifetime = RefreshTokenLifetime
+)
+
+var issuer = tokenIssuer()
+
+func tokenIssuer() string {
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		return iss
+	}
+	return "go-ecommerce"
+}
 
-var SECRET_KEY = os.Getenv("SECRET_KEY")
+func newTokenId() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(raw), nil
+}
 
-func TokenGenerator(email, firstName, lastName, uid string) (string, string, error) {
+// TokenGenerator issues an access and a refresh token for the given session
+func TokenGenerator(email, firstName, lastName, uid, role, sessionId string) (string, string, error) {
 	claims := &SignedDetails{
 		Email:     email,
 		FirstName: firstName,
 		LastName:  lastName,
 		Uid:       uid,
+		Role:      role,
+		TokenType: AccessToken,
+		SessionId: sessionId,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
+			Issuer:    issuer,
+			Subject:   uid,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Local().Add(AccessTokenLifetime).Unix(),
 		},
 	}
 
+	refreshId, err := newTokenId()
+	if err != nil {
+		return "", "", err
+	}
+
 	refreshClaims := &SignedDetails{
+		Uid:       uid,
+		TokenType: RefreshToken,
+		SessionId: sessionId,
 		StandardClaims: jwt.StandardClaims{
-			ExpiresAt: time.Now().Local().Add(time.Hour * time.Duration(24)).Unix(),
+			// a unique id makes every refresh token distinct, so rotation can tell them apart
+			Id:        refreshId,
+			Issuer:    issuer,
+			Subject:   uid,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Local().Add(RefreshTokenLifetime).Unix(),
 		},
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	token, err := keys.sign(claims)
 	if err != nil {
 		return "", "", err
 	}
 
-	refreshToken, err := jwt.NewWithClaims(jwt.SigningMethodHS384, refreshClaims).SignedString([]byte(SECRET_KEY))
+	refreshToken, err := keys.sign(refreshClaims)
 
 	if err != nil {
 		return "", "", err
@@ -58,10 +107,45 @@ func TokenGenerator(email, firstName, lastName, uid string) (string, string, err
 	return token, refreshToken, nil
 }
 
-func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
-	token, err := jwt.ParseWithClaims(signedToken, &SignedDetails{}, func(t *jwt.Token) (interface{}, error) {
-		return []byte(SECRET_KEY), nil
-	})
+// MFAChallengeGenerator issues the short-lived token returned by the first step of a
+// two-factor login. It only proves that the password was correct and cannot be used
+// as an access token.
+func MFAChallengeGenerator(uid string) (string, error) {
+	claims := &SignedDetails{
+		Uid:       uid,
+		TokenType: MFAChallengeToken,
+		StandardClaims: jwt.StandardClaims{
+			Issuer:    issuer,
+			Subject:   uid,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(mfaChallengeLifetime).Unix(),
+		},
+	}
+	return keys.sign(claims)
+}
+
+// MagicLinkGenerator issues the token embedded in an emailed login link. tokenId is the
+// plaintext of the single-use action token that guards it against replay.
+func MagicLinkGenerator(uid, tokenId string) (string, error) {
+	claims := &SignedDetails{
+		Uid:       uid,
+		TokenType: MagicLinkToken,
+		StandardClaims: jwt.StandardClaims{
+			Id:        tokenId,
+			Issuer:    issuer,
+			Subject:   uid,
+			IssuedAt:  time.Now().Unix(),
+			ExpiresAt: time.Now().Add(MagicLinkLifetime).Unix(),
+		},
+	}
+	return keys.sign(claims)
+}
+
+// ValidateToken verifies signedToken against the key ring and checks that it is a token
+// of the expected type issued by us.
+func ValidateToken(signedToken, tokenType string) (claims *SignedDetails, msg string) {
+	parser := jwt.NewParser(jwt.WithValidMethods(keys.validMethods()))
+	token, err := parser.ParseWithClaims(signedToken, &SignedDetails{}, keys.verificationKey)
 
 	if err != nil {
 		msg = err.Error()
@@ -78,32 +162,10 @@ func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
 		msg = "token is already expired"
 		return
 	}
-	return claims, msg
-}
 
-func UpdateAllTokens(signedToken, signedRefreshToken, userId string) {
-	var ctx, cancel = context.WithTimeout(context.Background(), 100*time.Second)
-
-	var updatedObj primitive.D
-	updatedObj = append(updatedObj, bson.E{Key: "token", Value: signedToken})
-	updatedObj = append(updatedObj, bson.E{Key: "refreshToken", Value: signedRefreshToken})
-
-	updatedAt, _ := time.Parse(time.RFC3339, time.Now().Format(time.RFC3339))
-	updatedObj = append(updatedObj, bson.E{Key: "updatedAt", Value: updatedAt})
-
-	upsert := true
-	filter := bson.M{"userId": userId}
-	opt := options.UpdateOptions{
-		Upsert: &upsert,
-	}
-
-	_, err := UserData.UpdateOne(ctx, filter, bson.D{
-		{Key: "$set", Value: updatedObj},
-	}, &opt)
-
-	defer cancel()
-	if err != nil {
-		log.Panic(err)
+	if claims.Issuer != issuer || claims.TokenType != tokenType {
+		msg = "token is invalid"
 		return
 	}
+	return claims, msg
 }
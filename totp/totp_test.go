@@ -0,0 +1,85 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// seeds of RFC 6238 Appendix B, one per hash algorithm
+var rfcSeeds = map[string][]byte{
+	SHA1:   []byte("12345678901234567890"),
+	SHA256: []byte("12345678901234567890123456789012"),
+	SHA512: []byte("1234567890123456789012345678901234567890123456789012345678901234"),
+}
+
+// TestCodeRFC6238Vectors checks the test vectors of RFC 6238 Appendix B, which use
+// 30 second steps and 8 digits
+func TestCodeRFC6238Vectors(t *testing.T) {
+	vectors := []struct {
+		unix  int64
+		codes map[string]string
+	}{
+		{59, map[string]string{SHA1: "94287082", SHA256: "46119246", SHA512: "90693936"}},
+		{1111111109, map[string]string{SHA1: "07081804", SHA256: "68084774", SHA512: "25091201"}},
+		{1111111111, map[string]string{SHA1: "14050471", SHA256: "67062674", SHA512: "99943326"}},
+		{1234567890, map[string]string{SHA1: "89005924", SHA256: "91819424", SHA512: "93441116"}},
+		{2000000000, map[string]string{SHA1: "69279037", SHA256: "90698825", SHA512: "38618901"}},
+		{20000000000, map[string]string{SHA1: "65353130", SHA256: "77737706", SHA512: "47863826"}},
+	}
+
+	for _, vector := range vectors {
+		for algorithm, want := range vector.codes {
+			opts := Options{Period: 30 * time.Second, Digits: 8, Algorithm: algorithm}
+			got := Code(rfcSeeds[algorithm], time.Unix(vector.unix, 0), opts)
+			if got != want {
+				t.Errorf("Code(%s, T=%d) = %s, want %s", algorithm, vector.unix, got, want)
+			}
+		}
+	}
+}
+
+func TestValidateDriftWindow(t *testing.T) {
+	secret := rfcSeeds[SHA1]
+	opts := DefaultOptions
+	now := time.Unix(1111111109, 0)
+	current := Step(now, opts)
+
+	for _, delta := range []int64{-1, 0, 1} {
+		code := Code(secret, now.Add(time.Duration(delta)*opts.Period), opts)
+		step, ok := Validate(secret, code, now, opts, 1)
+		if !ok {
+			t.Errorf("code of step %+d was rejected", delta)
+			continue
+		}
+		if step != current+delta {
+			t.Errorf("code of step %+d matched step %d, want %d", delta, step, current+delta)
+		}
+	}
+
+	for _, delta := range []int64{-2, 2} {
+		code := Code(secret, now.Add(time.Duration(delta)*opts.Period), opts)
+		if _, ok := Validate(secret, code, now, opts, 1); ok {
+			t.Errorf("code of step %+d was accepted outside the window", delta)
+		}
+	}
+
+	code := Code(secret, now.Add(-opts.Period), opts)
+	if _, ok := Validate(secret, code, now, opts, 0); ok {
+		t.Error("code of the previous step was accepted without skew")
+	}
+}
+
+func TestValidateRejectsMalformedCodes(t *testing.T) {
+	secret := rfcSeeds[SHA1]
+	now := time.Unix(59, 0)
+	code := Code(secret, now, DefaultOptions)
+
+	for _, candidate := range []string{"", code[:5], code + "0", "abcdef"} {
+		if _, ok := Validate(secret, candidate, now, DefaultOptions, 1); ok {
+			t.Errorf("Validate accepted %q", candidate)
+		}
+	}
+	if _, ok := Validate(secret, " "+code+" ", now, DefaultOptions, 1); !ok {
+		t.Error("Validate rejected a code with surrounding spaces")
+	}
+}